@@ -0,0 +1,555 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func configHistoryFromContext(c *gin.Context) *configHistory {
+	instance := c.Value("instance").(*ProxyInstance)
+	if instance.configHistory == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message":    "Config history is not enabled for this instance",
+			"statusCode": http.StatusNotFound,
+		})
+	}
+	return instance.configHistory
+}
+
+// List the configs in the instance's history, newest first.
+func ConfigHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		history := configHistoryFromContext(c)
+		if history == nil {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"versions": history.Versions()})
+	}
+}
+
+// Serve the raw body of a config in the instance's history.
+func ConfigHistoryVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		history := configHistoryFromContext(c)
+		if history == nil {
+			return
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid config version: " + c.Param("id"),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		version, body, ok := history.Version(id)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "Config version not found: " + c.Param("id"),
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		c.Header("ETag", version.ETag)
+		c.Header("Last-Modified", version.LastModified)
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
+
+// Pin the instance to a config in its history, given as {"id": <version>}.
+func PinConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		history := configHistoryFromContext(c)
+		if history == nil {
+			return
+		}
+		var body struct {
+			ID int `json:"id"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid JSON body",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		version, err := history.Pin(body.ID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    err.Error(),
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, version)
+	}
+}
+
+// Unpin the instance, so it picks up the latest config again.
+func UnpinConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		history := configHistoryFromContext(c)
+		if history == nil {
+			return
+		}
+		history.Unpin()
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Return the kill switch in effect on the instance.
+func GetKillSwitch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		c.JSON(http.StatusOK, instance.killSwitch.State())
+	}
+}
+
+// Replace the instance's kill switch, given as {"variables": [...], "values": {...}}.
+func SetKillSwitch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		var config KillSwitchConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid JSON body",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		instance.setKillSwitch(config)
+		c.JSON(http.StatusOK, instance.killSwitch.State())
+	}
+}
+
+// Deactivate the instance's kill switch.
+func ClearKillSwitch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		instance.setKillSwitch(KillSwitchConfig{})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Explain how the instance's current config evaluates a variable or feature for a user, given as
+// {"user": {...}, "variable": "<key>"} or {"user": {...}, "feature": "<key>"}. The variation and value are those the
+// DevCycle client served, with the evaluator annotating which targeting rules the user passed.
+func Explain() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
+		var body struct {
+			User     devcycle.User `json:"user"`
+			Variable string        `json:"variable"`
+			Feature  string        `json:"feature"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid JSON body",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		if (body.Variable == "") == (body.Feature == "") {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Exactly one of 'variable' or 'feature' is required",
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+
+		rawConfig, _, _, err := client.GetRawConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+		config, err := parseBucketingConfig(rawConfig)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+		user := bucketingUserAttributes(body.User, instance.PlatformData)
+		explanation, err := config.explain(body.Variable, body.Feature, user, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    err.Error(),
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+
+		// What the DevCycle client served is reported, with the evaluator's rules explaining it
+		features, err := client.AllFeatures(body.User)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+		// AllVariables doesn't track an evaluation, which a debugging lookup mustn't count as
+		var value interface{}
+		if body.Variable != "" {
+			variables, err := client.AllVariables(body.User)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{})
+				return
+			}
+			if variable, ok := variables[body.Variable]; ok {
+				value = variable.Value
+			}
+		}
+		explanation.useServed(config, features[explanation.Feature].Variation, value)
+
+		response := gin.H{"explanation": explanation}
+		if body.Variable != "" {
+			if _, forced, killed := instance.killSwitch.Variable(body.Variable); forced || killed {
+				response["killSwitch"] = true
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// The most users that can be evaluated in one what-if request, and the largest request body accepted.
+const (
+	maxWhatIfUsers     = 1000
+	maxWhatIfBodyBytes = 16 << 20
+)
+
+// WhatIfResult is the evaluation of a user against a candidate config.
+type WhatIfResult struct {
+	User      devcycle.User                        `json:"user"`
+	Variables map[string]devcycle.ReadOnlyVariable `json:"variables"`
+	Features  map[string]devcycle.Feature          `json:"features"`
+	Error     string                               `json:"error,omitempty"`
+}
+
+// Evaluate users against a candidate config, given as {"config": {...}, "users": [...]} with the config in the
+// /config/v2 format, without affecting the instance's own config.
+func WhatIf() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		var body struct {
+			Config json.RawMessage `json:"config"`
+			Users  []devcycle.User `json:"users"`
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxWhatIfBodyBytes)
+		if err := c.ShouldBindJSON(&body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+					"message":    fmt.Sprintf("The request body is larger than %d bytes", maxWhatIfBodyBytes),
+					"statusCode": http.StatusRequestEntityTooLarge,
+				})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid JSON body",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		_, err := parseBucketingConfig(body.Config)
+		if err != nil || !strings.HasPrefix(string(body.Config), "{") || len(body.Users) == 0 || len(body.Users) > maxWhatIfUsers {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    fmt.Sprintf("A config object and between 1 and %d users are required", maxWhatIfUsers),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+
+		client, err := newStaticConfigClient(instance.SDKKey, body.Config, instance.PlatformData, time.Hour)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid config",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		defer client.Close()
+
+		results := make([]WhatIfResult, 0, len(body.Users))
+		for _, user := range body.Users {
+			result := WhatIfResult{User: user}
+			if result.Variables, err = client.AllVariables(user); err == nil {
+				result.Features, err = client.AllFeatures(user)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	}
+}
+
+// The most users that can be evaluated in one simulation request.
+const maxSimulatedUsers = 100000
+
+// Simulate the distribution of a feature's variations over a sample of users, given either as
+// {"feature": "<key>", "users": [...]} or {"feature": "<key>", "generator": {...}}, optionally with a "config" in the
+// /config/v2 format to simulate instead of the instance's current config. Users can also be uploaded as NDJSON with a
+// Content-Type of application/x-ndjson and the feature key in the "feature" query parameter.
+func Simulate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
+		var body struct {
+			Feature   string          `json:"feature"`
+			Config    json.RawMessage `json:"config"`
+			Users     []devcycle.User `json:"users"`
+			Generator *UserGenerator  `json:"generator"`
+		}
+		var users UserSource
+		if c.ContentType() == "application/x-ndjson" {
+			body.Feature = c.Query("feature")
+			users = NDJSONUsers(c.Request.Body)
+		} else {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"message":    "Invalid JSON body",
+					"exception":  err.Error(),
+					"statusCode": http.StatusBadRequest,
+				})
+				return
+			}
+			if (len(body.Users) == 0) == (body.Generator == nil) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"message":    "Exactly one of 'users' or 'generator' is required",
+					"statusCode": http.StatusBadRequest,
+				})
+				return
+			}
+			if body.Generator != nil {
+				users = body.Generator.Users()
+			} else {
+				users = func(each func(user devcycle.User) error) error {
+					for _, user := range body.Users {
+						if err := each(user); err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+			}
+		}
+		if body.Feature == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "A feature key is required",
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+
+		rawConfig := []byte(body.Config)
+		if len(rawConfig) == 0 {
+			var err error
+			if rawConfig, _, _, err = client.GetRawConfig(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{})
+				return
+			}
+		} else if !strings.HasPrefix(string(rawConfig), "{") {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "The config must be an object",
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		config, err := parseBucketingConfig(rawConfig)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid config",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		if config.feature(body.Feature) == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "Feature not found in config: " + body.Feature,
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+
+		count := 0
+		limited := func(each func(user devcycle.User) error) error {
+			return users(func(user devcycle.User) error {
+				if count++; count > maxSimulatedUsers {
+					return fmt.Errorf("at most %d users can be simulated", maxSimulatedUsers)
+				}
+				return each(user)
+			})
+		}
+		// A supplied config is evaluated by a client of its own, and the current config by the instance's client
+		allFeatures := client.AllFeatures
+		if len(body.Config) > 0 {
+			configClient, err := newStaticConfigClient(instance.SDKKey, rawConfig, instance.PlatformData, time.Hour)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"message":    "Invalid config",
+					"exception":  err.Error(),
+					"statusCode": http.StatusBadRequest,
+				})
+				return
+			}
+			defer configClient.Close()
+			allFeatures = configClient.AllFeatures
+		}
+		distribution, err := config.simulateDistribution(body.Feature, instance.PlatformData, allFeatures, limited, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid users",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, distribution)
+	}
+}
+
+func userProfilesFromContext(c *gin.Context) *userProfileStore {
+	instance := c.Value("instance").(*ProxyInstance)
+	if instance.userProfiles == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message":    "User profiles are not enabled for this instance",
+			"statusCode": http.StatusNotFound,
+		})
+	}
+	return instance.userProfiles
+}
+
+// Return the profile stored for a user.
+func GetUserProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := userProfilesFromContext(c)
+		if store == nil {
+			return
+		}
+		profile, ok := store.Get(c.Param("userId"))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "User profile not found",
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// Store the profile for a user, given as {"email": ..., "country": ..., "customData": {...},
+// "privateCustomData": {...}}.
+func PutUserProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := userProfilesFromContext(c)
+		if store == nil {
+			return
+		}
+		var user devcycle.User
+		if err := c.ShouldBindJSON(&user); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid JSON body",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		profile := userProfileFromUser(user)
+		profile.UserId = c.Param("userId")
+		if err := store.Put(profile); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid user profile",
+				"exception":  err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		profile, _ = store.Get(profile.UserId)
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// Delete the profile stored for a user.
+func DeleteUserProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := userProfilesFromContext(c)
+		if store == nil {
+			return
+		}
+		if !store.Delete(c.Param("userId")) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "User profile not found",
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Store profiles in bulk, given as a JSON array of users, or as NDJSON with a Content-Type of application/x-ndjson.
+// Profiles that can't be stored, such as those without a user_id or over the size limit, are counted as rejected
+// without failing the rest.
+func ImportUserProfiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := userProfilesFromContext(c)
+		if store == nil {
+			return
+		}
+		var users UserSource
+		if c.ContentType() == "application/x-ndjson" {
+			users = NDJSONUsers(c.Request.Body)
+		} else {
+			var body []devcycle.User
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"message":    "Invalid JSON body",
+					"exception":  err.Error(),
+					"statusCode": http.StatusBadRequest,
+				})
+				return
+			}
+			users = func(each func(user devcycle.User) error) error {
+				for _, user := range body {
+					if err := each(user); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+
+		imported, rejected := 0, 0
+		err := users(func(user devcycle.User) error {
+			if err := store.Put(userProfileFromUser(user)); err != nil {
+				rejected++
+			} else {
+				imported++
+			}
+			return nil
+		})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    "Invalid user profiles",
+				"exception":  err.Error(),
+				"imported":   imported,
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"imported": imported, "rejected": rejected, "profiles": store.Len()})
+	}
+}
@@ -0,0 +1,91 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminTestRequest(r http.Handler, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestWhatIf(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rawConfig := sdkTestConfig(t)
+	live, err := newStaticConfigClient("dvc_server_what_if", rawConfig, devcycle.PlatformData{}, time.Hour)
+	require.NoError(t, err)
+	defer live.Close()
+	r := newRouter(live.Client, &ProxyInstance{SDKKey: "dvc_server_what_if", AdminToken: "admin-token"})
+
+	// The candidate serves the control variation to internal users, who get the treatment live
+	var candidate map[string]interface{}
+	require.NoError(t, json.Unmarshal(rawConfig, &candidate))
+	feature := candidate["features"].([]interface{})[0].(map[string]interface{})
+	target := feature["configuration"].(map[string]interface{})["targets"].([]interface{})[0].(map[string]interface{})
+	target["distribution"] = []map[string]interface{}{{"_variation": "var-a", "percentage": 1}}
+	body, err := json.Marshal(map[string]interface{}{
+		"config": candidate,
+		"users":  []devcycle.User{{UserId: "internal", Email: "dev@example.com"}, {UserId: "nobody"}},
+	})
+	require.NoError(t, err)
+
+	w := adminTestRequest(r, "/admin/what-if", string(body))
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Results []WhatIfResult `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Results, 2)
+	internal := response.Results[0]
+	assert.Empty(t, internal.Error)
+	assert.Equal(t, false, internal.Variables["new-checkout"].Value)
+	assert.Equal(t, "var-a", internal.Features["checkout"].Variation)
+	assert.NotContains(t, response.Results[1].Variables, "new-checkout")
+
+	// The live config is untouched
+	liveConfig, _, _, err := live.GetRawConfig()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(rawConfig), string(liveConfig))
+	variables, err := live.AllVariables(devcycle.User{UserId: "internal", Email: "dev@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, true, variables["new-checkout"].Value)
+}
+
+func TestWhatIfRequestLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := newRouter(nil, &ProxyInstance{SDKKey: "dvc_server_what_if", AdminToken: "admin-token"})
+
+	users := make([]string, maxWhatIfUsers+1)
+	for i := range users {
+		users[i] = fmt.Sprintf(`{"user_id": "u%d"}`, i)
+	}
+	for _, test := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "no users", body: `{"config": {}, "users": []}`, status: http.StatusBadRequest},
+		{name: "no config", body: `{"users": [{"user_id": "u1"}]}`, status: http.StatusBadRequest},
+		{name: "too many users", body: `{"config": {}, "users": [` + strings.Join(users, ",") + `]}`, status: http.StatusBadRequest},
+		{name: "body too large", body: `{"config": {"padding": "` + strings.Repeat("x", maxWhatIfBodyBytes) + `"}}`, status: http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.status, adminTestRequest(r, "/admin/what-if", test.body).Code)
+		})
+	}
+}
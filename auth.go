@@ -1,6 +1,7 @@
 package sdk_proxy
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
@@ -48,3 +49,98 @@ func DevCycleAuthRequired() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Whether a key or token presented by a caller grants access to this instance's config and SSE stream.
+func (i *ProxyInstance) isPermittedKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	// The emulator's SDK key is a placeholder, so SDKs can use any key with it
+	if i.emulator != nil {
+		return true
+	}
+	permitted := false
+	for _, allowed := range append([]string{i.SDKKey}, i.PermittedTokens...) {
+		if allowed != "" && subtle.ConstantTimeCompare([]byte(key), []byte(allowed)) == 1 {
+			permitted = true
+		}
+	}
+	return permitted
+}
+
+// Only serve the config for the path's SDK key if it is this instance's key, or one of its permitted tokens, unless
+// the instance is mirroring the config CDN for other keys.
+func ConfigAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		if c.Param("sdkKey") == "" || !strings.HasSuffix(c.Param("sdkKey"), ".json") {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if !instance.isPermittedKey(strings.TrimSuffix(c.Param("sdkKey"), ".json")) {
+			// Other keys are proxied to the config CDN when mirroring is enabled
+			if instance.configMirror != nil {
+				c.Set("config_mirror", true)
+				c.Next()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "Config not found",
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Require the 'sdkKey' query parameter or 'Authorization' header to match the instance before subscribing to SSE.
+// The config served by the proxy adds the key to the SSE path, so SDKs connect with it automatically.
+func SSEAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		sdkKey := c.Query("sdkKey")
+		if header := c.GetHeader("Authorization"); header != "" {
+			sdkKey = strings.TrimPrefix(header, "Bearer ")
+		}
+		if sdkKey == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message":    "Missing 'sdkKey' query parameter or 'Authorization' header",
+				"statusCode": http.StatusUnauthorized,
+			})
+			return
+		}
+		if !instance.isPermittedKey(sdkKey) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message":    "Invalid SDK key",
+				"statusCode": http.StatusUnauthorized,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Require the instance's admin token as a bearer token on the admin API. The admin API is disabled unless an admin
+// token is configured.
+func AdminAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instance := c.Value("instance").(*ProxyInstance)
+		if instance.AdminToken == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "The admin API is not enabled for this instance",
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(instance.AdminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message":    "Invalid admin token",
+				"statusCode": http.StatusUnauthorized,
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,56 @@
+package sdk_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigAndSSEAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	instance := &ProxyInstance{
+		SDKKey:          "dvc_server_instance_key",
+		PermittedTokens: []string{"internal-token"},
+	}
+	r := gin.New()
+	r.Use(sdkProxyMiddleware(instance))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/config/v2/server/:sdkKey", ConfigAuthRequired(), ok)
+	r.GET("/event-stream", SSEAuthRequired(), ok)
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		expected int
+	}{
+		{name: "config for instance key", path: "/config/v2/server/dvc_server_instance_key.json", expected: http.StatusOK},
+		{name: "config for permitted token", path: "/config/v2/server/internal-token.json", expected: http.StatusOK},
+		{name: "config for other key", path: "/config/v2/server/dvc_server_other_key.json", expected: http.StatusNotFound},
+		{name: "config without json suffix", path: "/config/v2/server/dvc_server_instance_key", expected: http.StatusForbidden},
+		{name: "sse without key", path: "/event-stream", expected: http.StatusUnauthorized},
+		{name: "sse with query key", path: "/event-stream?sdkKey=dvc_server_instance_key", expected: http.StatusOK},
+		{name: "sse with header key", path: "/event-stream", header: "Bearer dvc_server_instance_key", expected: http.StatusOK},
+		{name: "sse with permitted token", path: "/event-stream?sdkKey=internal-token", expected: http.StatusOK},
+		{name: "sse with other key", path: "/event-stream?sdkKey=dvc_server_other_key", expected: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, test.expected, w.Code)
+		})
+	}
+}
+
+func TestWithQueryParam(t *testing.T) {
+	assert.Equal(t, "/event-stream?sdkKey=key", withQueryParam("/event-stream", "sdkKey", "key"))
+	assert.Equal(t, "/event-stream?channel=abc&sdkKey=key", withQueryParam("/event-stream?channel=abc", "sdkKey", "key"))
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	sdkproxy "github.com/devcyclehq/sdk-proxy/v2"
 	"github.com/kelseyhightower/envconfig"
@@ -25,6 +26,13 @@ variables can be used:
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := runSimulate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var configPath string
 	var showConfig bool
 	flag.StringVar(&configPath, "config", "", "The path to a JSON config file.")
@@ -34,6 +42,7 @@ func main() {
 		log.Printf("DevCycle Local Bucketing Proxy Version %s\n", Version)
 
 		log.Printf("Usage: %s [options]\n", os.Args[0])
+		log.Printf("       %s simulate [options]\n", os.Args[0])
 		flag.PrintDefaults()
 		_ = envconfig.Usagef(sdkproxy.EnvVarPrefix, &sdkproxy.FullEnvConfig{}, os.Stderr, EnvConfigFormat)
 	}
@@ -76,8 +85,11 @@ func main() {
 		s := <-c
 		fmt.Printf("Received signal: %s, shutting down", s)
 
+		// Give in-flight requests a little time to finish
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelShutdown()
 		for _, instance := range config.Instances {
-			err := instance.Close()
+			err := instance.Shutdown(shutdownCtx)
 			if err != nil {
 				log.Printf("Failed to shut down instance: %s", err)
 			}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	sdkproxy "github.com/devcyclehq/sdk-proxy/v2"
+)
+
+// runSimulate implements the simulate subcommand, printing the distribution of a feature's variations over a sample of
+// users as JSON.
+func runSimulate(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	feature := flags.String("feature", "", "The key of the feature to simulate.")
+	configFile := flags.String("config-file", "", "The path to a /config/v2 config to simulate.")
+	sdkKey := flags.String("sdk-key", "", "The server SDK key to fetch the current config for, when no config file is given.")
+	configCDNURI := flags.String("config-cdn-uri", "https://config-cdn.devcycle.com", "The URI of the Config CDN to fetch the current config from.")
+	usersFile := flags.String("users", "", "The path to a sample of users, as newline-delimited JSON.")
+	generator := flags.String("generator", "", "The path to a JSON spec for generating a sample of users, instead of a users file.")
+	platform := flags.String("platform", "", "The platform users are evaluated as being on.")
+	platformVersion := flags.String("platform-version", "", "The platform version users are evaluated as being on.")
+	flags.Usage = func() {
+		log.Printf("Usage: %s simulate -feature <key> (-config-file <path> | -sdk-key <key>) (-users <path> | -generator <path>)\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *feature == "" || (*configFile == "") == (*sdkKey == "") || (*usersFile == "") == (*generator == "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var rawConfig []byte
+	var err error
+	if *configFile != "" {
+		rawConfig, err = os.ReadFile(*configFile)
+	} else {
+		rawConfig, err = fetchConfig(*configCDNURI, *sdkKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	var users sdkproxy.UserSource
+	if *usersFile != "" {
+		file, err := os.Open(*usersFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		users = sdkproxy.NDJSONUsers(file)
+	} else {
+		spec, err := os.ReadFile(*generator)
+		if err != nil {
+			return err
+		}
+		var userGenerator sdkproxy.UserGenerator
+		if err = json.Unmarshal(spec, &userGenerator); err != nil {
+			return fmt.Errorf("invalid generator spec: %v", err)
+		}
+		users = userGenerator.Users()
+	}
+
+	platformData := devcycle.PlatformData{SdkType: "server", Platform: *platform, PlatformVersion: *platformVersion}
+	distribution, err := sdkproxy.SimulateDistribution(rawConfig, *feature, platformData, users, time.Now())
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(distribution)
+}
+
+func fetchConfig(configCDNURI, sdkKey string) ([]byte, error) {
+	url := fmt.Sprintf("%s/config/v2/server/%s.json", strings.TrimSuffix(configCDNURI, "/"), sdkKey)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch config: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
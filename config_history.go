@@ -0,0 +1,251 @@
+package sdk_proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ConfigHistoryConfig struct {
+	Enabled     bool `json:"enabled,omitempty" desc:"Whether to keep a history of the instance's configs, which it can be pinned to through the admin API. Defaults to false."`
+	MaxVersions int  `json:"maxVersions,omitempty" split_words:"true" desc:"The number of configs kept in the history. Defaults to 10."`
+}
+
+func (c *ConfigHistoryConfig) Default() {
+	if c.Enabled && c.MaxVersions == 0 {
+		c.MaxVersions = 10
+	}
+}
+
+// ConfigVersion is a config the instance has received from the config CDN.
+type ConfigVersion struct {
+	ID           int       `json:"id"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"lastModified,omitempty"`
+	ReceivedAt   time.Time `json:"receivedAt"`
+	Pinned       bool      `json:"pinned"`
+	body         []byte
+}
+
+// configHistory is a loopback config CDN that the DevCycle client is pointed at. It passes requests through to the
+// real config CDN, recording the last few configs it returns, and while pinned serves one of those instead so the
+// instance stops picking up config changes.
+type configHistory struct {
+	upstreamURI string
+	maxVersions int
+	httpClient  *http.Client
+	listener    net.Listener
+	server      *http.Server
+
+	mu       sync.RWMutex
+	nextID   int
+	versions []*ConfigVersion
+	pinned   *ConfigVersion
+	// The headers the pinned config is served with, fresh for each pin so the DevCycle client doesn't take it for an
+	// older config than the one it has
+	pinnedETag         string
+	pinnedLastModified time.Time
+	// The last config served to the DevCycle client, which ignores configs with an older Last-Modified
+	servedETag         string
+	servedLastModified time.Time
+}
+
+func newConfigHistory(config ConfigHistoryConfig, upstreamURI string, requestTimeout time.Duration) (*configHistory, error) {
+	config.Default()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	h := &configHistory{
+		upstreamURI: upstreamURI,
+		maxVersions: config.MaxVersions,
+		httpClient:  &http.Client{Timeout: requestTimeout},
+		listener:    listener,
+		nextID:      1,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config/", h.handleConfig)
+	h.server = &http.Server{Handler: mux}
+	go func() {
+		if err := h.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error running config history server: %s", err)
+		}
+	}()
+	return h, nil
+}
+
+func (h *configHistory) URL() string {
+	return "http://" + h.listener.Addr().String()
+}
+
+func (h *configHistory) Close() error {
+	return h.server.Close()
+}
+
+// Versions returns the configs in the history, newest first.
+func (h *configHistory) Versions() []ConfigVersion {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	versions := make([]ConfigVersion, 0, len(h.versions))
+	for v := len(h.versions) - 1; v >= 0; v-- {
+		version := *h.versions[v]
+		version.Pinned = h.versions[v] == h.pinned
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// Version returns a config in the history and its body.
+func (h *configHistory) Version(id int) (ConfigVersion, []byte, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, version := range h.versions {
+		if version.ID == id {
+			result := *version
+			result.Pinned = version == h.pinned
+			return result, version.body, true
+		}
+	}
+	return ConfigVersion{}, nil, false
+}
+
+// Pinned returns the config the instance is pinned to, if any.
+func (h *configHistory) Pinned() (ConfigVersion, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.pinned == nil {
+		return ConfigVersion{}, false
+	}
+	version := *h.pinned
+	version.Pinned = true
+	return version, true
+}
+
+// Pin serves a config from the history to the DevCycle client until Unpin is called. The client picks it up the next
+// time it polls for config, as it's served with a new ETag and Last-Modified.
+func (h *configHistory) Pin(id int) (ConfigVersion, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, version := range h.versions {
+		if version.ID == id {
+			h.pinned = version
+			h.pinnedETag = fmt.Sprintf(`"pinned-%d-%d"`, version.ID, time.Now().UnixNano())
+			h.pinnedLastModified = h.serve(h.pinnedETag, time.Now())
+			log.Printf("Pinned config to version %d (%s)", version.ID, version.ETag)
+			result := *version
+			result.Pinned = true
+			return result, nil
+		}
+	}
+	return ConfigVersion{}, fmt.Errorf("config version %d not found", id)
+}
+
+func (h *configHistory) Unpin() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pinned != nil {
+		log.Printf("Unpinned config from version %d (%s)", h.pinned.ID, h.pinned.ETag)
+	}
+	h.pinned = nil
+}
+
+func (h *configHistory) record(body []byte, etag, lastModified string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Without an ETag, an unchanged config can only be told apart by its body
+	if n := len(h.versions); n > 0 {
+		last := h.versions[n-1]
+		if (etag != "" && last.ETag == etag) || (etag == "" && last.ETag == "" && bytes.Equal(last.body, body)) {
+			return
+		}
+	}
+	h.versions = append(h.versions, &ConfigVersion{
+		ID:           h.nextID,
+		ETag:         etag,
+		LastModified: lastModified,
+		ReceivedAt:   time.Now().UTC(),
+		body:         body,
+	})
+	h.nextID++
+	// The pinned version is kept regardless of its age
+	for len(h.versions) > h.maxVersions {
+		evict := 0
+		if h.versions[0] == h.pinned {
+			evict = 1
+		}
+		h.versions = append(h.versions[:evict], h.versions[evict+1:]...)
+	}
+}
+
+// Returns the Last-Modified to serve a config with, moved past that of the last config served if it isn't newer, so
+// the DevCycle client picks up pinned configs and the configs served again once unpinned. Must be called with the
+// lock held.
+func (h *configHistory) serve(etag string, lastModified time.Time) time.Time {
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	if !lastModified.After(h.servedLastModified) {
+		if etag == h.servedETag {
+			return h.servedLastModified
+		}
+		lastModified = h.servedLastModified.Add(time.Second)
+	}
+	h.servedETag, h.servedLastModified = etag, lastModified
+	return lastModified
+}
+
+func (h *configHistory) handleConfig(w http.ResponseWriter, req *http.Request) {
+	h.mu.RLock()
+	pinned, etag, lastModified := h.pinned, h.pinnedETag, h.pinnedLastModified
+	h.mu.RUnlock()
+	if pinned != nil {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(pinned.body)
+		return
+	}
+
+	upstream, err := http.NewRequestWithContext(req.Context(), "GET", h.upstreamURI+req.URL.RequestURI(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if etag := req.Header.Get("If-None-Match"); etag != "" {
+		upstream.Header.Set("If-None-Match", etag)
+	}
+	resp, err := h.httpClient.Do(upstream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode == http.StatusOK {
+		h.record(body, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	}
+	for _, header := range []string{"Content-Type", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && resp.StatusCode == http.StatusOK {
+		h.mu.Lock()
+		modified = h.serve(resp.Header.Get("ETag"), modified)
+		h.mu.Unlock()
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(body)
+}
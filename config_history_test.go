@@ -0,0 +1,157 @@
+package sdk_proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fetchConfig(t *testing.T, url, etag string) (int, string, string) {
+	status, headers, body := fetchConfigHeaders(t, url, etag)
+	return status, headers.Get("ETag"), body
+}
+
+func fetchConfigHeaders(t *testing.T, url, etag string) (int, http.Header, string) {
+	req, err := http.NewRequest("GET", url+"/config/v2/server/dvc_server_key.json", nil)
+	require.NoError(t, err)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header, string(body)
+}
+
+func TestConfigHistoryPinning(t *testing.T) {
+	upstreamVersion := "1"
+	lastModified := time.Now().UTC().Add(-time.Hour)
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+upstreamVersion+`"`)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		_, _ = w.Write([]byte(`{"version":` + upstreamVersion + `}`))
+	}))
+	defer cdn.Close()
+
+	history, err := newConfigHistory(ConfigHistoryConfig{Enabled: true, MaxVersions: 2}, cdn.URL, time.Second)
+	require.NoError(t, err)
+	defer history.Close()
+
+	_, _, body := fetchConfig(t, history.URL(), "")
+	assert.Equal(t, `{"version":1}`, body)
+	upstreamVersion = "2"
+	fetchConfig(t, history.URL(), "")
+	fetchConfig(t, history.URL(), "")
+
+	versions := history.Versions()
+	require.Len(t, versions, 2)
+	assert.Equal(t, `"2"`, versions[0].ETag)
+	assert.Equal(t, `"1"`, versions[1].ETag)
+
+	_, err = history.Pin(versions[1].ID)
+	require.NoError(t, err)
+	upstreamVersion = "3"
+	lastModified = lastModified.Add(time.Minute)
+	// The pinned config is served as a new config, so the client doesn't ignore it for being older than its own
+	status, headers, body := fetchConfigHeaders(t, history.URL(), `"2"`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotContains(t, []string{`"1"`, `"2"`}, headers.Get("ETag"))
+	pinnedModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	require.NoError(t, err)
+	assert.True(t, pinnedModified.After(lastModified))
+	assert.Equal(t, `{"version":1}`, body)
+	pinnedETag := headers.Get("ETag")
+	status, _, _ = fetchConfig(t, history.URL(), pinnedETag)
+	assert.Equal(t, http.StatusNotModified, status)
+
+	// The pinned version survives newer configs being recorded
+	history.record([]byte(`{"version":4}`), `"4"`, "")
+	history.record([]byte(`{"version":5}`), `"5"`, "")
+	pinned, ok := history.Pinned()
+	require.True(t, ok)
+	assert.Equal(t, `"1"`, pinned.ETag)
+
+	// Once unpinned, the upstream config is served as newer than the pinned one
+	history.Unpin()
+	status, headers, body = fetchConfigHeaders(t, history.URL(), pinnedETag)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"version":3}`, body)
+	unpinnedModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	require.NoError(t, err)
+	assert.True(t, unpinnedModified.After(pinnedModified))
+	_, headers, _ = fetchConfigHeaders(t, history.URL(), "")
+	assert.Equal(t, unpinnedModified.Format(http.TimeFormat), headers.Get("Last-Modified"))
+}
+
+func TestConfigHistoryWithoutETags(t *testing.T) {
+	upstreamVersion := "1"
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":` + upstreamVersion + `}`))
+	}))
+	defer cdn.Close()
+
+	history, err := newConfigHistory(ConfigHistoryConfig{Enabled: true, MaxVersions: 2}, cdn.URL, time.Second)
+	require.NoError(t, err)
+	defer history.Close()
+
+	// Unchanged bodies don't push real versions out of the history
+	for i := 0; i < 3; i++ {
+		fetchConfig(t, history.URL(), "")
+	}
+	require.Len(t, history.Versions(), 1)
+	upstreamVersion = "2"
+	fetchConfig(t, history.URL(), "")
+	fetchConfig(t, history.URL(), "")
+	versions := history.Versions()
+	require.Len(t, versions, 2)
+	for i, want := range []string{`{"version":2}`, `{"version":1}`} {
+		_, body, ok := history.Version(versions[i].ID)
+		require.True(t, ok)
+		assert.Equal(t, want, string(body))
+	}
+}
+
+func TestConfigHistoryAdminAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	history, err := newConfigHistory(ConfigHistoryConfig{Enabled: true}, "http://127.0.0.1:1", time.Second)
+	require.NoError(t, err)
+	defer history.Close()
+	history.record([]byte(`{}`), `"1"`, "")
+
+	instance := &ProxyInstance{AdminToken: "admin-token", configHistory: history}
+	r := newRouter(nil, instance)
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/config/history", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/config/history", "wrong", "").Code)
+
+	w := request("GET", "/admin/config/history", "admin-token", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"etag":"\"1\""`)
+	assert.Equal(t, `{}`, request("GET", "/admin/config/history/1", "admin-token", "").Body.String())
+	assert.Equal(t, http.StatusNotFound, request("PUT", "/admin/config/pin", "admin-token", `{"id":7}`).Code)
+	assert.Equal(t, http.StatusOK, request("PUT", "/admin/config/pin", "admin-token", `{"id":1}`).Code)
+	assert.Contains(t, request("GET", "/healthz", "", "").Body.String(), `"pinnedConfig"`)
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/admin/config/pin", "admin-token", "").Code)
+
+	instance.AdminToken = ""
+	assert.Equal(t, http.StatusNotFound, request("GET", "/admin/config/history", "", "").Code)
+}
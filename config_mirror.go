@@ -0,0 +1,237 @@
+package sdk_proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConfigMirrorConfig struct {
+	Enabled           bool  `json:"enabled,omitempty" desc:"Whether to serve configs for SDK keys not bound to this instance by mirroring them from the config CDN. Defaults to false."`
+	RefreshIntervalMS int64 `json:"refreshIntervalMS,omitempty" split_words:"true" desc:"The interval at which mirrored configs are refreshed from the config CDN in milliseconds. Defaults to 30000."`
+	EvictAfterMS      int64 `json:"evictAfterMS,omitempty" split_words:"true" desc:"How long a mirrored config is kept without being requested before it is evicted in milliseconds. Defaults to 3600000."`
+	MaxEntries        int   `json:"maxEntries,omitempty" split_words:"true" desc:"The maximum number of configs to mirror before evicting the least recently requested. Defaults to 1000."`
+	FailureCacheMS    int64 `json:"failureCacheMS,omitempty" envconfig:"FAILURE_CACHE_MS" desc:"How long a failed or non-200 config lookup is cached before the config CDN is asked again in milliseconds. Defaults to 5000."`
+}
+
+func (c *ConfigMirrorConfig) Default() {
+	if !c.Enabled {
+		return
+	}
+	if c.RefreshIntervalMS == 0 {
+		c.RefreshIntervalMS = 30000
+	}
+	if c.EvictAfterMS == 0 {
+		c.EvictAfterMS = 3600000
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = 1000
+	}
+	if c.FailureCacheMS == 0 {
+		c.FailureCacheMS = 5000
+	}
+}
+
+type mirroredConfig struct {
+	// Serializes fetches of the same config, so concurrent first requests only hit the CDN once
+	fetchMu sync.Mutex
+
+	mu           sync.RWMutex
+	body         []byte
+	etag         string
+	lastModified string
+	lastAccessed time.Time
+	// The outcome of the last lookup of a config the CDN didn't serve, so unknown SDK keys don't hit the CDN on every
+	// request
+	failedAt     time.Time
+	failedStatus int
+	failedErr    error
+}
+
+// configMirror is a caching mirror of the config CDN. Configs are fetched when first requested, refreshed in the
+// background using ETags, and evicted once they haven't been requested for a while.
+type configMirror struct {
+	config     ConfigMirrorConfig
+	cdnURI     string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	entries map[string]*mirroredConfig
+}
+
+func newConfigMirror(ctx context.Context, config ConfigMirrorConfig, cdnURI string, requestTimeout time.Duration) *configMirror {
+	config.Default()
+	m := &configMirror{
+		config:     config,
+		cdnURI:     cdnURI,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		entries:    make(map[string]*mirroredConfig),
+	}
+	go m.refreshLoop(ctx)
+	return m
+}
+
+func (m *configMirror) path(version, sdkKey string) string {
+	return fmt.Sprintf("/config/%s/server/%s.json", version, sdkKey)
+}
+
+// Get returns the mirrored config for an SDK key, fetching it from the CDN if it isn't cached yet. A non-200 status
+// is returned as-is when the CDN doesn't have the config, and like errors fetching it, is cached for FailureCacheMS.
+func (m *configMirror) Get(version, sdkKey string) (body []byte, etag, lastModified string, status int, err error) {
+	path := m.path(version, sdkKey)
+
+	m.mu.Lock()
+	entry, ok := m.entries[path]
+	if !ok {
+		entry = &mirroredConfig{}
+		m.entries[path] = entry
+	}
+	entry.lastAccessed = time.Now()
+	if !ok {
+		m.evictOverflow()
+	}
+	m.mu.Unlock()
+
+	entry.fetchMu.Lock()
+	defer entry.fetchMu.Unlock()
+	entry.mu.RLock()
+	body, etag, lastModified = entry.body, entry.etag, entry.lastModified
+	failedAt, failedStatus, failedErr := entry.failedAt, entry.failedStatus, entry.failedErr
+	entry.mu.RUnlock()
+	if body != nil {
+		return body, etag, lastModified, http.StatusOK, nil
+	}
+	if !failedAt.IsZero() && time.Since(failedAt) < time.Duration(m.config.FailureCacheMS)*time.Millisecond {
+		return nil, "", "", failedStatus, failedErr
+	}
+
+	status, err = m.fetch(path, entry)
+	if err != nil || status != http.StatusOK {
+		entry.mu.Lock()
+		entry.failedAt, entry.failedStatus, entry.failedErr = time.Now(), status, err
+		entry.mu.Unlock()
+		return nil, "", "", status, err
+	}
+	entry.mu.RLock()
+	defer entry.mu.RUnlock()
+	return entry.body, entry.etag, entry.lastModified, http.StatusOK, nil
+}
+
+// Fetch a config from the CDN into an entry, sending the cached ETag so unchanged configs aren't downloaded again.
+func (m *configMirror) fetch(path string, entry *mirroredConfig) (int, error) {
+	req, err := http.NewRequest("GET", m.cdnURI+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	entry.mu.RLock()
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	entry.mu.RUnlock()
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return http.StatusOK, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	entry.mu.Lock()
+	entry.body = body
+	entry.etag = resp.Header.Get("ETag")
+	entry.lastModified = resp.Header.Get("Last-Modified")
+	entry.mu.Unlock()
+	return http.StatusOK, nil
+}
+
+// Drop the least recently requested configs until the mirror is within its size limit. Must be called with the lock held.
+func (m *configMirror) evictOverflow() {
+	for len(m.entries) > m.config.MaxEntries {
+		var oldestPath string
+		var oldest time.Time
+		for path, entry := range m.entries {
+			if oldestPath == "" || entry.lastAccessed.Before(oldest) {
+				oldestPath, oldest = path, entry.lastAccessed
+			}
+		}
+		delete(m.entries, oldestPath)
+	}
+}
+
+func (m *configMirror) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(m.config.RefreshIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.refresh()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *configMirror) refresh() {
+	evictBefore := time.Now().Add(-time.Duration(m.config.EvictAfterMS) * time.Millisecond)
+	m.mu.Lock()
+	refresh := make(map[string]*mirroredConfig, len(m.entries))
+	for path, entry := range m.entries {
+		if entry.lastAccessed.Before(evictBefore) {
+			delete(m.entries, path)
+			continue
+		}
+		entry.mu.RLock()
+		mirrored := entry.body != nil
+		entry.mu.RUnlock()
+		// Configs that failed are looked up again when next requested instead
+		if mirrored {
+			refresh[path] = entry
+		}
+	}
+	m.mu.Unlock()
+
+	for path, entry := range refresh {
+		entry.fetchMu.Lock()
+		status, err := m.fetch(path, entry)
+		entry.fetchMu.Unlock()
+		if err != nil {
+			log.Printf("Error refreshing mirrored config %s: %s", path, err)
+		} else if status != http.StatusOK {
+			log.Printf("Config CDN responded with status %d refreshing mirrored config %s", status, path)
+		}
+	}
+}
+
+func serveMirroredConfig(c *gin.Context, mirror *configMirror, version string) {
+	body, etag, lastModified, status, err := mirror.Get(version, strings.TrimSuffix(c.Param("sdkKey"), ".json"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"message": "Error fetching config: " + err.Error()})
+		return
+	}
+	if status != http.StatusOK {
+		c.Status(status)
+		return
+	}
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified)
+	if etag != "" && c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", body)
+}
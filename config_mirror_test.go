@@ -0,0 +1,96 @@
+package sdk_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigMirror(t *testing.T) {
+	var fetches, notModified, notFound int32
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config/v2/server/dvc_server_mirrored.json" {
+			atomic.AddInt32(&notFound, 1)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		if r.Header.Get("If-None-Match") == `"etag-1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"etag-1"`)
+		_, _ = w.Write([]byte(`{"project":{}}`))
+	}))
+	defer cdn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mirror := newConfigMirror(ctx, ConfigMirrorConfig{
+		Enabled:           true,
+		RefreshIntervalMS: time.Hour.Milliseconds(),
+		FailureCacheMS:    100,
+	}, cdn.URL, time.Second)
+
+	body, etag, _, status, err := mirror.Get("v2", "dvc_server_mirrored")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"project":{}}`, string(body))
+	assert.Equal(t, `"etag-1"`, etag)
+
+	// Served from the cache
+	_, _, _, _, err = mirror.Get("v2", "dvc_server_mirrored")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// Refreshing sends the cached ETag
+	mirror.refresh()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+
+	// Unknown keys are cached for a while too, and aren't refreshed in the background
+	for n := 0; n < 3; n++ {
+		_, _, _, status, err = mirror.Get("v2", "dvc_server_unknown")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status)
+	}
+	mirror.refresh()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notFound))
+	time.Sleep(150 * time.Millisecond)
+	_, _, _, status, _ = mirror.Get("v2", "dvc_server_unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&notFound))
+}
+
+func TestConfigMirrorEviction(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer cdn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mirror := newConfigMirror(ctx, ConfigMirrorConfig{
+		Enabled:           true,
+		RefreshIntervalMS: time.Hour.Milliseconds(),
+		EvictAfterMS:      1,
+		MaxEntries:        2,
+	}, cdn.URL, time.Second)
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, _, _, _, err := mirror.Get("v2", key)
+		require.NoError(t, err)
+	}
+	assert.Len(t, mirror.entries, 2)
+	assert.NotContains(t, mirror.entries, "/config/v2/server/a.json")
+
+	time.Sleep(5 * time.Millisecond)
+	mirror.refresh()
+	assert.Empty(t, mirror.entries)
+}
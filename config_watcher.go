@@ -0,0 +1,109 @@
+package sdk_proxy
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// How often the DevCycle client's config is checked for changes. This only reads the client's copy of the config,
+// so it doesn't add any requests to the config CDN.
+const configWatchInterval = time.Second
+
+// watchedConfig is a raw config as returned by the DevCycle client.
+type watchedConfig struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+}
+
+func (c watchedConfig) sameAs(other watchedConfig) bool {
+	if c.ETag != "" || other.ETag != "" {
+		return c.ETag == other.ETag
+	}
+	return bytes.Equal(c.Body, other.Body)
+}
+
+// configWatcher polls the DevCycle client's raw config and notifies subscribers whenever it changes.
+type configWatcher struct {
+	get      func() ([]byte, string, string, error)
+	interval time.Duration
+
+	mu          sync.RWMutex
+	current     watchedConfig
+	nextID      int
+	subscribers map[int]func(previous, current watchedConfig)
+}
+
+func newConfigWatcher(get func() ([]byte, string, string, error), interval time.Duration) *configWatcher {
+	return &configWatcher{
+		get:         get,
+		interval:    interval,
+		subscribers: make(map[int]func(previous, current watchedConfig)),
+	}
+}
+
+// Subscribe registers a function called with the previous and current config each time the config changes. The
+// first config loaded by the client isn't reported as a change. The returned function removes the subscription.
+func (w *configWatcher) Subscribe(fn func(previous, current watchedConfig)) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = fn
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers, id)
+	}
+}
+
+// Current returns the most recently seen config.
+func (w *configWatcher) Current() watchedConfig {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+func (w *configWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.poll()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *configWatcher) poll() {
+	body, etag, lastModified, err := w.get()
+	// The client returns an error until its first config has loaded
+	if err != nil || len(body) == 0 {
+		return
+	}
+	next := watchedConfig{Body: body, ETag: etag, LastModified: lastModified}
+
+	w.mu.Lock()
+	previous := w.current
+	if previous.Body != nil && previous.sameAs(next) {
+		w.mu.Unlock()
+		return
+	}
+	w.current = next
+	subscribers := make([]func(previous, current watchedConfig), 0, len(w.subscribers))
+	for _, fn := range w.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	w.mu.Unlock()
+
+	if previous.Body == nil {
+		return
+	}
+	for _, fn := range subscribers {
+		fn(previous, next)
+	}
+}
@@ -0,0 +1,31 @@
+package sdk_proxy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	config := watchedConfig{}
+	var err error = errors.New("not initialized")
+	watcher := newConfigWatcher(func() ([]byte, string, string, error) {
+		return config.Body, config.ETag, config.LastModified, err
+	}, configWatchInterval)
+
+	var changes [][2]string
+	watcher.Subscribe(func(previous, current watchedConfig) {
+		changes = append(changes, [2]string{previous.ETag, current.ETag})
+	})
+
+	watcher.poll()
+	config, err = watchedConfig{Body: []byte(`{}`), ETag: `"1"`}, nil
+	watcher.poll()
+	watcher.poll()
+	config = watchedConfig{Body: []byte(`{"a":1}`), ETag: `"2"`}
+	watcher.poll()
+
+	assert.Equal(t, [][2]string{{`"1"`, `"2"`}}, changes)
+	assert.Equal(t, `"2"`, watcher.Current().ETag)
+}
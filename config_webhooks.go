@@ -0,0 +1,203 @@
+package sdk_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// How many config changes can be waiting for delivery to a webhook before new ones are dropped.
+const configWebhookQueueSize = 100
+
+type ConfigWebhookConfig struct {
+	URL              string            `json:"url"`
+	Secret           string            `json:"secret,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	RequestTimeoutMS int64             `json:"requestTimeoutMS,omitempty"`
+	MaxRetries       int               `json:"maxRetries,omitempty"`
+}
+
+func (c *ConfigWebhookConfig) Default() {
+	if c.RequestTimeoutMS == 0 {
+		c.RequestTimeoutMS = 10000
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+}
+
+// ConfigChange is the body POSTed to config webhooks when an instance's config changes.
+type ConfigChange struct {
+	Project      string     `json:"project,omitempty"`
+	Environment  string     `json:"environment,omitempty"`
+	PreviousETag string     `json:"previousETag"`
+	ETag         string     `json:"etag"`
+	LastModified string     `json:"lastModified,omitempty"`
+	ChangedAt    time.Time  `json:"changedAt"`
+	Changes      ConfigDiff `json:"changes"`
+}
+
+// ConfigDiff summarises the differences between two configs by key.
+type ConfigDiff struct {
+	Features  KeyedDiff `json:"features"`
+	Variables KeyedDiff `json:"variables"`
+	// Keys of the features whose targeting changed
+	Targeting []string `json:"targeting"`
+}
+
+type KeyedDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// The parts of a config that are diffed. Features and variables are kept as generic maps so that fields the proxy
+// doesn't know about still count as modifications.
+type diffableConfig struct {
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Environment struct {
+		Key string `json:"key"`
+	} `json:"environment"`
+	Features  []map[string]interface{} `json:"features"`
+	Variables []map[string]interface{} `json:"variables"`
+}
+
+func keyedEntities(entities []map[string]interface{}) map[string]map[string]interface{} {
+	keyed := make(map[string]map[string]interface{}, len(entities))
+	for _, entity := range entities {
+		if key, ok := entity["key"].(string); ok {
+			keyed[key] = entity
+		}
+	}
+	return keyed
+}
+
+// Diff two sets of keyed entities. Fields listed in ignore aren't considered when checking for modifications.
+func diffEntities(previous, current map[string]map[string]interface{}, ignore ...string) KeyedDiff {
+	diff := KeyedDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	without := func(entity map[string]interface{}) map[string]interface{} {
+		stripped := make(map[string]interface{}, len(entity))
+		for field, value := range entity {
+			stripped[field] = value
+		}
+		for _, field := range ignore {
+			delete(stripped, field)
+		}
+		return stripped
+	}
+	for key, entity := range current {
+		old, ok := previous[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+		} else if !reflect.DeepEqual(without(old), without(entity)) {
+			diff.Modified = append(diff.Modified, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+	return diff
+}
+
+func diffConfigs(previousBody, currentBody []byte) (ConfigDiff, diffableConfig, error) {
+	var previous, current diffableConfig
+	if err := json.Unmarshal(previousBody, &previous); err != nil {
+		return ConfigDiff{}, current, err
+	}
+	if err := json.Unmarshal(currentBody, &current); err != nil {
+		return ConfigDiff{}, current, err
+	}
+	previousFeatures, currentFeatures := keyedEntities(previous.Features), keyedEntities(current.Features)
+	diff := ConfigDiff{
+		Features:  diffEntities(previousFeatures, currentFeatures, "configuration"),
+		Variables: diffEntities(keyedEntities(previous.Variables), keyedEntities(current.Variables)),
+		Targeting: []string{},
+	}
+	for key, feature := range currentFeatures {
+		if old, ok := previousFeatures[key]; ok && !reflect.DeepEqual(old["configuration"], feature["configuration"]) {
+			diff.Targeting = append(diff.Targeting, key)
+		}
+	}
+	sort.Strings(diff.Targeting)
+	return diff, current, nil
+}
+
+// configWebhook delivers config changes to a webhook one at a time, in the order they happened.
+type configWebhook struct {
+	config     ConfigWebhookConfig
+	httpClient *http.Client
+	changes    chan []byte
+}
+
+func newConfigWebhook(ctx context.Context, config ConfigWebhookConfig) (*configWebhook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("config webhook url must be set")
+	}
+	config.Default()
+	w := &configWebhook{
+		config:     config,
+		httpClient: &http.Client{Timeout: time.Duration(config.RequestTimeoutMS) * time.Millisecond},
+		changes:    make(chan []byte, configWebhookQueueSize),
+	}
+	go w.run(ctx)
+	return w, nil
+}
+
+func (w *configWebhook) send(body []byte) {
+	select {
+	case w.changes <- body:
+	default:
+		log.Printf("Config webhook %s is too far behind, dropping config change", w.config.URL)
+	}
+}
+
+func (w *configWebhook) run(ctx context.Context) {
+	for {
+		select {
+		case body := <-w.changes:
+			err := postSignedWebhook(ctx, w.httpClient, w.config.URL, w.config.Secret, w.config.Headers, body, w.config.MaxRetries)
+			if err != nil {
+				log.Printf("Error delivering config change to webhook %s: %s", w.config.URL, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Notify the instance's config webhooks of a config change.
+func (i *ProxyInstance) notifyConfigWebhooks(previous, current watchedConfig) {
+	diff, config, err := diffConfigs(previous.Body, current.Body)
+	if err != nil {
+		log.Printf("Error diffing configs for config webhooks: %s", err)
+		return
+	}
+	body, err := json.Marshal(ConfigChange{
+		Project:      config.Project.Key,
+		Environment:  config.Environment.Key,
+		PreviousETag: previous.ETag,
+		ETag:         current.ETag,
+		LastModified: current.LastModified,
+		ChangedAt:    time.Now().UTC(),
+		Changes:      diff,
+	})
+	if err != nil {
+		log.Printf("Error marshalling config change: %s", err)
+		return
+	}
+	for _, webhook := range i.configWebhooks {
+		webhook.send(body)
+	}
+}
@@ -0,0 +1,94 @@
+package sdk_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const previousWebhookConfig = `{
+	"project": {"key": "project"},
+	"environment": {"key": "production"},
+	"features": [
+		{"key": "same", "type": "release", "configuration": {"targets": []}},
+		{"key": "retargeted", "type": "release", "configuration": {"targets": []}},
+		{"key": "renamed", "name": "Old", "configuration": {"targets": []}},
+		{"key": "removed", "configuration": {}}
+	],
+	"variables": [
+		{"key": "same", "type": "Boolean"},
+		{"key": "retyped", "type": "Boolean"}
+	]
+}`
+
+const currentWebhookConfig = `{
+	"project": {"key": "project"},
+	"environment": {"key": "production"},
+	"features": [
+		{"key": "same", "type": "release", "configuration": {"targets": []}},
+		{"key": "retargeted", "type": "release", "configuration": {"targets": [{"_id": "t1"}]}},
+		{"key": "renamed", "name": "New", "configuration": {"targets": []}},
+		{"key": "added", "configuration": {}}
+	],
+	"variables": [
+		{"key": "same", "type": "Boolean"},
+		{"key": "retyped", "type": "String"},
+		{"key": "added", "type": "Number"}
+	]
+}`
+
+func TestDiffConfigs(t *testing.T) {
+	diff, _, err := diffConfigs([]byte(previousWebhookConfig), []byte(currentWebhookConfig))
+	require.NoError(t, err)
+	assert.Equal(t, ConfigDiff{
+		Features:  KeyedDiff{Added: []string{"added"}, Removed: []string{"removed"}, Modified: []string{"renamed"}},
+		Variables: KeyedDiff{Added: []string{"added"}, Removed: []string{}, Modified: []string{"retyped"}},
+		Targeting: []string{"retargeted"},
+	}, diff)
+}
+
+func TestConfigWebhook(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webhook, err := newConfigWebhook(ctx, ConfigWebhookConfig{URL: server.URL, Secret: "secret"})
+	require.NoError(t, err)
+	instance := &ProxyInstance{configWebhooks: []*configWebhook{webhook}}
+
+	instance.notifyConfigWebhooks(
+		watchedConfig{Body: []byte(previousWebhookConfig), ETag: `"1"`},
+		watchedConfig{Body: []byte(currentWebhookConfig), ETag: `"2"`},
+	)
+
+	select {
+	case req := <-received:
+		body := <-bodies
+		assert.Equal(t, signPayload("secret", body), req.Header.Get(SignatureHeader))
+		var change ConfigChange
+		require.NoError(t, json.Unmarshal(body, &change))
+		assert.Equal(t, "production", change.Environment)
+		assert.Equal(t, `"1"`, change.PreviousETag)
+		assert.Equal(t, `"2"`, change.ETag)
+		assert.Equal(t, []string{"retargeted"}, change.Changes.Targeting)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	_, err = newConfigWebhook(ctx, ConfigWebhookConfig{})
+	assert.EqualError(t, err, "config webhook url must be set")
+}
@@ -0,0 +1,313 @@
+package sdk_proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EmulatorSDKKey stands in for the SDK key of an instance running the emulator, which needs none.
+const EmulatorSDKKey = "dvc_server_emulator"
+
+// How often the emulator checks its flag file for changes, and the DevCycle client checks the emulator for config.
+var emulatorWatchInterval = time.Second
+
+type EmulatorConfig struct {
+	FlagFile  string `json:"flagFile,omitempty" split_words:"true" desc:"The path to a YAML or JSON flag file to serve variables from instead of DevCycle. Setting it runs the instance as an emulator, with no SDK key or network access needed."`
+	LogEvents bool   `json:"logEvents,omitempty" split_words:"true" desc:"Whether the emulator logs the events it receives, rather than discarding them silently. Defaults to false."`
+}
+
+func (c EmulatorConfig) Enabled() bool {
+	return c.FlagFile != ""
+}
+
+// emulatorFlagFile is a hand-written set of variable values, keyed by variable key.
+type emulatorFlagFile struct {
+	Variables map[string]emulatorVariable `yaml:"variables"`
+}
+
+// emulatorVariable is the value served for a variable, and the values served instead to users matching its targets,
+// checked in order. A variable can also be given as just its value.
+type emulatorVariable struct {
+	Value   interface{}      `yaml:"value"`
+	Targets []emulatorTarget `yaml:"targets"`
+}
+
+// emulatorTarget matches users by user ID, or by attributes, all of which must match one of their values. A target
+// with neither matches every user.
+type emulatorTarget struct {
+	Users      []string               `yaml:"users"`
+	Attributes map[string]interface{} `yaml:"attributes"`
+	Value      interface{}            `yaml:"value"`
+}
+
+func (v *emulatorVariable) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		explicit := len(node.Content) > 0
+		for k := 0; k < len(node.Content); k += 2 {
+			if key := node.Content[k].Value; key != "value" && key != "targets" {
+				explicit = false
+			}
+		}
+		if explicit {
+			type plain emulatorVariable
+			return node.Decode((*plain)(v))
+		}
+	}
+	return node.Decode(&v.Value)
+}
+
+func parseEmulatorFlagFile(data []byte) (*emulatorFlagFile, error) {
+	var flags emulatorFlagFile
+	// JSON is valid YAML, so either format can be decoded the same way
+	if err := yaml.Unmarshal(data, &flags); err != nil {
+		return nil, err
+	}
+	for key, variable := range flags.Variables {
+		if key != strings.ToLower(key) {
+			return nil, fmt.Errorf("variable key %s must be lowercase", key)
+		}
+		if _, err := variable.variableType(); err != nil {
+			return nil, fmt.Errorf("variable %s: %v", key, err)
+		}
+	}
+	return &flags, nil
+}
+
+// The type of a variable, which its value and target values must all share.
+func (v emulatorVariable) variableType() (string, error) {
+	values := []interface{}{v.Value}
+	for _, target := range v.Targets {
+		if target.Value == nil {
+			return "", fmt.Errorf("a value is required for every target")
+		}
+		values = append(values, target.Value)
+	}
+	varType := ""
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		if valueType := variableType(value); varType == "" {
+			varType = valueType
+		} else if valueType != varType {
+			return "", fmt.Errorf("values must all be of the same type, found %s and %s", varType, valueType)
+		}
+	}
+	if varType == "" {
+		return "", fmt.Errorf("a value is required")
+	}
+	return varType, nil
+}
+
+// The audience filters for a target.
+func (t emulatorTarget) filters() ([]interface{}, error) {
+	filters := []interface{}{}
+	if len(t.Users) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"type": "user", "subType": "user_id", "comparator": "=", "values": t.Users,
+		})
+	}
+	attributes := make([]string, 0, len(t.Attributes))
+	for attribute := range t.Attributes {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	for _, attribute := range attributes {
+		values, ok := t.Attributes[attribute].([]interface{})
+		if !ok {
+			values = []interface{}{t.Attributes[attribute]}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("no values given for attribute %s", attribute)
+		}
+		filter := map[string]interface{}{"type": "user", "comparator": "=", "values": values}
+		if key, ok := strings.CutPrefix(attribute, "customData."); ok {
+			filter["subType"] = "customData"
+			filter["dataKey"] = key
+			filter["dataKeyType"] = variableType(values[0])
+		} else {
+			switch attribute {
+			case "user_id", "email", "country", "appVersion", "platform", "platformVersion", "deviceModel":
+				filter["subType"] = attribute
+			default:
+				return nil, fmt.Errorf("unknown attribute %s, expected a user field or customData.<key>", attribute)
+			}
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 0 {
+		filters = append(filters, map[string]interface{}{"type": "all"})
+	}
+	return filters, nil
+}
+
+// config builds a /config/v2 config serving the flag file's variables. Each variable gets a feature of the same key,
+// with a variation for its value and one for each of its targets.
+func (f emulatorFlagFile) config() ([]byte, error) {
+	keys := make([]string, 0, len(f.Variables))
+	for key := range f.Variables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	features := make([]interface{}, 0, len(keys))
+	variables := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		variable := f.Variables[key]
+		varType, err := variable.variableType()
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %v", key, err)
+		}
+		variableID := "variable-" + key
+		featureID := "feature-" + key
+		variables = append(variables, map[string]interface{}{"_id": variableID, "key": key, "type": varType})
+
+		variations := []interface{}{}
+		targets := []interface{}{}
+		addTarget := func(variationKey string, value interface{}, filters []interface{}) {
+			variationID := featureID + "-" + variationKey
+			variations = append(variations, map[string]interface{}{
+				"_id":       variationID,
+				"key":       variationKey,
+				"name":      variationKey,
+				"variables": []interface{}{map[string]interface{}{"_var": variableID, "value": value}},
+			})
+			targets = append(targets, map[string]interface{}{
+				"_id": featureID + "-target-" + variationKey,
+				"_audience": map[string]interface{}{
+					"_id":     featureID + "-audience-" + variationKey,
+					"filters": map[string]interface{}{"operator": "and", "filters": filters},
+				},
+				"distribution": []interface{}{map[string]interface{}{"_variation": variationID, "percentage": 1}},
+			})
+		}
+		for t, target := range variable.Targets {
+			filters, err := target.filters()
+			if err != nil {
+				return nil, fmt.Errorf("variable %s target %d: %v", key, t+1, err)
+			}
+			addTarget(fmt.Sprintf("target-%d", t+1), target.Value, filters)
+		}
+		// Users not matching a target get the variable's value, or are not served the variable if it has none
+		if variable.Value != nil {
+			addTarget("default", variable.Value, []interface{}{map[string]interface{}{"type": "all"}})
+		}
+		features = append(features, map[string]interface{}{
+			"_id":           featureID,
+			"key":           key,
+			"type":          "release",
+			"variations":    variations,
+			"configuration": map[string]interface{}{"_id": featureID + "-configuration", "targets": targets},
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"project": map[string]interface{}{
+			"_id":             "emulator",
+			"key":             "emulator",
+			"a0_organization": "emulator",
+			"settings":        map[string]interface{}{"edgeDB": map[string]interface{}{"enabled": false}},
+		},
+		"environment":    map[string]interface{}{"_id": "emulator", "key": "emulator"},
+		"audiences":      map[string]interface{}{},
+		"features":       features,
+		"variables":      variables,
+		"variableHashes": map[string]interface{}{},
+		"sse":            map[string]interface{}{"hostname": "", "path": "/event-stream"},
+	})
+}
+
+// emulator serves the config built from a flag file to the instance's DevCycle client, in place of the config CDN and
+// events API, rebuilding it when the file changes.
+type emulator struct {
+	config  EmulatorConfig
+	server  *staticConfigServer
+	modTime time.Time
+	size    int64
+}
+
+func newEmulator(config EmulatorConfig) (*emulator, error) {
+	e := &emulator{config: config}
+	body, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	var onEvents func([]byte)
+	if config.LogEvents {
+		onEvents = func(payload []byte) {
+			log.Printf("Emulator received events: %s", bytes.TrimSpace(payload))
+		}
+	}
+	e.server, err = newStaticConfigServer(body, onEvents)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Emulating DevCycle with flags from %s", config.FlagFile)
+	return e, nil
+}
+
+func (e *emulator) URL() string {
+	return e.server.URL()
+}
+
+func (e *emulator) Close() error {
+	return e.server.Close()
+}
+
+// Read the flag file and build its config.
+func (e *emulator) load() ([]byte, error) {
+	info, err := os.Stat(e.config.FlagFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading flag file: %v", err)
+	}
+	data, err := os.ReadFile(e.config.FlagFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading flag file: %v", err)
+	}
+	flags, err := parseEmulatorFlagFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flag file %s: %v", e.config.FlagFile, err)
+	}
+	body, err := flags.config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid flag file %s: %v", e.config.FlagFile, err)
+	}
+	e.modTime, e.size = info.ModTime(), info.Size()
+	return body, nil
+}
+
+// watch reloads the flag file when it changes, until ctx is cancelled. A file that fails to load is logged, and the
+// last good config kept.
+func (e *emulator) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(e.config.FlagFile)
+			if err != nil || (info.ModTime().Equal(e.modTime) && info.Size() == e.size) {
+				continue
+			}
+			body, err := e.load()
+			if err != nil {
+				log.Printf("Error reloading emulator flags: %s", err)
+				// Don't retry the same broken file every tick
+				e.modTime, e.size = info.ModTime(), info.Size()
+				continue
+			}
+			e.server.SetConfig(body)
+			log.Printf("Reloaded emulator flags from %s", e.config.FlagFile)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sdk_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const emulatorTestFlags = `
+variables:
+  banner-text: Welcome
+  new-checkout:
+    value: false
+    targets:
+      - users: [alice, bob]
+        value: true
+      - attributes:
+          country: [CA, GB]
+          customData.plan: pro
+        value: true
+  theme:
+    value: {colour: blue}
+  beta-only:
+    targets:
+      - attributes:
+          email: dev@example.com
+        value: 3
+`
+
+func TestEmulatorConfig(t *testing.T) {
+	flags, err := parseEmulatorFlagFile([]byte(emulatorTestFlags))
+	require.NoError(t, err)
+	body, err := flags.config()
+	require.NoError(t, err)
+	config, err := parseBucketingConfig(body)
+	require.NoError(t, err)
+	types, err := configVariableTypes(body)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"banner-text":  VariableTypeString,
+		"new-checkout": VariableTypeBoolean,
+		"theme":        VariableTypeJSON,
+		"beta-only":    VariableTypeNumber,
+	}, types)
+
+	value := func(key string, user devcycle.User) interface{} {
+		explanation, err := config.explain(key, "", bucketingUserAttributes(user, devcycle.PlatformData{}), time.Now())
+		require.NoError(t, err)
+		return explanation.Value
+	}
+	assert.Equal(t, "Welcome", value("banner-text", devcycle.User{UserId: "carol"}))
+	assert.Equal(t, map[string]interface{}{"colour": "blue"}, value("theme", devcycle.User{UserId: "carol"}))
+	assert.Equal(t, true, value("new-checkout", devcycle.User{UserId: "bob"}))
+	assert.Equal(t, false, value("new-checkout", devcycle.User{UserId: "carol", Country: "CA"}))
+	assert.Equal(t, true, value("new-checkout", devcycle.User{UserId: "carol", Country: "CA", CustomData: map[string]interface{}{"plan": "pro"}}))
+	assert.Equal(t, float64(3), value("beta-only", devcycle.User{UserId: "carol", Email: "dev@example.com"}))
+	assert.Nil(t, value("beta-only", devcycle.User{UserId: "carol"}))
+
+	// JSON flag files work too
+	flags, err = parseEmulatorFlagFile([]byte(`{"variables": {"banner-text": {"value": "Hi"}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", flags.Variables["banner-text"].Value)
+
+	for name, invalid := range map[string]string{
+		"mixed types":       "variables: {flag: {value: true, targets: [{users: [a], value: on}]}}",
+		"unknown attribute": "variables: {flag: {value: true, targets: [{attributes: {shoeSize: 9}, value: false}]}}",
+		"no value":          "variables: {flag: {targets: []}}",
+		"uppercase key":     "variables: {Flag: true}",
+	} {
+		flags, err := parseEmulatorFlagFile([]byte(invalid))
+		if err == nil {
+			_, err = flags.config()
+		}
+		assert.Error(t, err, name)
+	}
+}
+
+func TestEmulatorReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("variables: {banner-text: one}"), 0644))
+	emulator, err := newEmulator(EmulatorConfig{FlagFile: path})
+	require.NoError(t, err)
+	defer emulator.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go emulator.watch(ctx, 10*time.Millisecond)
+
+	status, etag, body := fetchConfig(t, emulator.URL(), "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"one"`)
+
+	// A broken file keeps the last good config
+	require.NoError(t, os.WriteFile(path, []byte("variables: {banner-text: [unclosed"), 0644))
+	time.Sleep(50 * time.Millisecond)
+	status, _, _ = fetchConfig(t, emulator.URL(), etag)
+	assert.Equal(t, http.StatusNotModified, status)
+
+	require.NoError(t, os.WriteFile(path, []byte("variables: {banner-text: two}"), 0644))
+	assert.Eventually(t, func() bool {
+		status, _, body = fetchConfig(t, emulator.URL(), etag)
+		return status == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, body, `"two"`)
+}
+
+func TestEmulatorConfigV1(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "flags.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("variables: {banner-text: one}"), 0644))
+	emulator, err := newEmulator(EmulatorConfig{FlagFile: path})
+	require.NoError(t, err)
+	defer emulator.Close()
+	r := newRouter(nil, &ProxyInstance{emulator: emulator})
+
+	// Answered locally rather than by the real config CDN, with the placeholder key
+	req := httptest.NewRequest("GET", "/config/v1/server/dvc_server_any.json", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "/config/v2")
+}
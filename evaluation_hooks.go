@@ -0,0 +1,147 @@
+package sdk_proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// The endpoints evaluation hooks are called from.
+const (
+	EvaluationEndpointVariable  = "variable"
+	EvaluationEndpointVariables = "variables"
+	EvaluationEndpointFeatures  = "features"
+	EvaluationEndpointTrack     = "track"
+)
+
+// EvaluationContext describes an evaluation, or a tracked event, to evaluation hooks.
+type EvaluationContext struct {
+	// The context of the request being handled
+	Context context.Context
+	Request *http.Request
+	// One of the EvaluationEndpoint constants
+	Endpoint string
+	// The key of the variable evaluated by the variable endpoint, otherwise empty
+	VariableKey string
+	// The user being evaluated, which Before and OnTrack hooks may change
+	User *devcycle.User
+}
+
+// EvaluationResult is the result of an evaluation, which After hooks may change. Only the field for the endpoint
+// evaluated is set.
+type EvaluationResult struct {
+	Variable  *devcycle.Variable
+	Variables map[string]devcycle.ReadOnlyVariable
+	Features  map[string]devcycle.Feature
+}
+
+// EvaluationHook runs custom logic around the evaluations made by the Variable and Feature endpoints, and the events
+// sent to Track. Hooks are called in the order they were added. An error returned by a hook vetoes the request, which
+// fails with the error's status if it is a HookError, or a 500 otherwise. Implementations must be safe for concurrent
+// use. Embed NoopEvaluationHook to implement only some of the methods.
+type EvaluationHook interface {
+	// Before is called before a user is evaluated, and can enrich or replace the user.
+	Before(ctx *EvaluationContext) error
+	// After is called with the result of an evaluation, and can rewrite it.
+	After(ctx *EvaluationContext, result *EvaluationResult) error
+	// OnError is called when an evaluation or tracked event fails, including when it is vetoed by a hook.
+	OnError(ctx *EvaluationContext, err error)
+	// OnTrack is called for each event sent to Track before it is forwarded, and can change the event.
+	OnTrack(ctx *EvaluationContext, event *devcycle.Event) error
+}
+
+// NoopEvaluationHook implements EvaluationHook by doing nothing.
+type NoopEvaluationHook struct{}
+
+func (NoopEvaluationHook) Before(*EvaluationContext) error                   { return nil }
+func (NoopEvaluationHook) After(*EvaluationContext, *EvaluationResult) error { return nil }
+func (NoopEvaluationHook) OnError(*EvaluationContext, error)                 {}
+func (NoopEvaluationHook) OnTrack(*EvaluationContext, *devcycle.Event) error { return nil }
+
+// HookError is returned by an evaluation hook to veto a request with a particular response.
+type HookError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HookError) Error() string {
+	return e.Message
+}
+
+func (i *ProxyInstance) AddEvaluationHook(hook EvaluationHook) {
+	i.evaluationHooksMu.Lock()
+	defer i.evaluationHooksMu.Unlock()
+	i.evaluationHooks = append(i.evaluationHooks, hook)
+}
+
+func (i *ProxyInstance) hooks() []EvaluationHook {
+	i.evaluationHooksMu.RLock()
+	defer i.evaluationHooksMu.RUnlock()
+	return i.evaluationHooks
+}
+
+func newEvaluationContext(c *gin.Context, endpoint, variableKey string, user *devcycle.User) *EvaluationContext {
+	return &EvaluationContext{
+		Context:     c.Request.Context(),
+		Request:     c.Request,
+		Endpoint:    endpoint,
+		VariableKey: variableKey,
+		User:        user,
+	}
+}
+
+func (i *ProxyInstance) runBeforeHooks(ctx *EvaluationContext) error {
+	for _, hook := range i.hooks() {
+		if err := hook.Before(ctx); err != nil {
+			i.runErrorHooks(ctx, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *ProxyInstance) runAfterHooks(ctx *EvaluationContext, result *EvaluationResult) error {
+	for _, hook := range i.hooks() {
+		if err := hook.After(ctx, result); err != nil {
+			i.runErrorHooks(ctx, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *ProxyInstance) runErrorHooks(ctx *EvaluationContext, err error) {
+	for _, hook := range i.hooks() {
+		hook.OnError(ctx, err)
+	}
+}
+
+func (i *ProxyInstance) runTrackHooks(ctx *EvaluationContext, event *devcycle.Event) error {
+	for _, hook := range i.hooks() {
+		if err := hook.OnTrack(ctx, event); err != nil {
+			i.runErrorHooks(ctx, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Fail a request vetoed by an evaluation hook.
+func abortWithHookError(c *gin.Context, err error) {
+	var hookErr *HookError
+	if errors.As(err, &hookErr) && hookErr.StatusCode != 0 {
+		c.AbortWithStatusJSON(hookErr.StatusCode, gin.H{
+			"message":    hookErr.Message,
+			"statusCode": hookErr.StatusCode,
+		})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+		"message":    "Evaluation hook failed",
+		"exception":  err.Error(),
+		"statusCode": http.StatusInternalServerError,
+	})
+}
@@ -0,0 +1,129 @@
+package sdk_proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHook struct {
+	NoopEvaluationHook
+	mu     sync.Mutex
+	calls  []string
+	errors []error
+	veto   error
+}
+
+func (h *recordingHook) record(call string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, call)
+}
+
+func (h *recordingHook) Before(ctx *EvaluationContext) error {
+	h.record("before:" + ctx.Endpoint + ":" + ctx.User.UserId)
+	ctx.User.Email = "enriched@example.com"
+	return h.veto
+}
+
+func (h *recordingHook) After(ctx *EvaluationContext, result *EvaluationResult) error {
+	h.record("after:" + ctx.VariableKey + ":" + ctx.User.Email)
+	if result.Variable != nil {
+		result.Variable.Value = "rewritten"
+	}
+	return nil
+}
+
+func (h *recordingHook) OnError(_ *EvaluationContext, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errors = append(h.errors, err)
+}
+
+func (h *recordingHook) OnTrack(ctx *EvaluationContext, event *devcycle.Event) error {
+	h.record("track:" + ctx.User.UserId + ":" + event.CustomType)
+	event.CustomType = "renamed"
+	return nil
+}
+
+func TestEvaluationHooks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	instance := &ProxyInstance{
+		SDKKey:           "dvc_server_hooks",
+		DisableEventsAPI: true,
+		killSwitch:       newKillSwitch(KillSwitchConfig{Values: map[string]interface{}{"greeting": "hello"}}),
+	}
+	hook := &recordingHook{}
+	instance.AddEvaluationHook(hook)
+	r := newRouter(nil, instance)
+	request := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "dvc_server_hooks")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("/v1/variables/greeting", `{"user_id": "u1"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"value":"rewritten"`)
+	assert.Equal(t, []string{"before:variable:u1", "after:greeting:enriched@example.com"}, hook.calls)
+
+	hook.calls = nil
+	w = request("/v1/track", `{"user": {"user_id": "u2"}, "events": [{"type": "customEvent", "customType": "purchase"}]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, []string{"track:u2:purchase"}, hook.calls)
+
+	hook.veto = &HookError{StatusCode: http.StatusForbidden, Message: "Not allowed"}
+	w = request("/v1/variables/greeting", `{"user_id": "u1"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Not allowed")
+	hook.veto = errors.New("boom")
+	w = request("/v1/features", `{"user_id": "u1"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "boom")
+	assert.Len(t, hook.errors, 2)
+}
+
+type replacingHook struct {
+	NoopEvaluationHook
+	user devcycle.User
+}
+
+func (h *replacingHook) Before(ctx *EvaluationContext) error {
+	ctx.User = &h.user
+	return nil
+}
+
+func (h *replacingHook) OnTrack(ctx *EvaluationContext, _ *devcycle.Event) error {
+	ctx.User = &h.user
+	return nil
+}
+
+func TestEvaluationHooksReplaceUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	instance := &ProxyInstance{
+		SDKKey:           "dvc_server_hooks",
+		DisableEventsAPI: true,
+	}
+	instance.AddEvaluationHook(&replacingHook{user: devcycle.User{UserId: "replaced"}})
+	sink := &recordingSink{}
+	instance.AddEventSink(sink)
+	r := newRouter(nil, instance)
+
+	req := httptest.NewRequest("POST", "/v1/track", strings.NewReader(`{"user": {"user_id": "u1"}, "events": [{"type": "customEvent", "customType": "purchase"}]}`))
+	req.Header.Set("Authorization", "dvc_server_hooks")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, "replaced", sink.events[0].User["user_id"])
+}
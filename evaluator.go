@@ -0,0 +1,593 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/twmb/murmur3"
+)
+
+// The evaluator reimplements the DevCycle bucketing rules so that the proxy can explain an evaluation step by step,
+// which the DevCycle client doesn't expose. The DevCycle client remains the source of truth for evaluations served to
+// callers.
+
+// Evaluation reasons, as reported by the DevCycle SDKs.
+const (
+	EvalReasonTargetingMatch = "TARGETING_MATCH"
+	EvalReasonSplit          = "SPLIT"
+	EvalReasonDefault        = "DEFAULT"
+
+	// Reported by explanations whose evaluation disagrees with the DevCycle client's.
+	EvalReasonEvaluatorMismatch = "EVALUATOR_MISMATCH"
+
+	DefaultDetailsNotInRollout = "Not In Rollout"
+)
+
+const (
+	bucketingBaseSeed = 1
+	maxHashValue      = math.MaxUint32
+)
+
+// bucketingConfig is the part of a /config/v2 config used to evaluate targeting.
+type bucketingConfig struct {
+	Project struct {
+		Settings struct {
+			DisablePassthroughRollouts bool `json:"disablePassthroughRollouts"`
+		} `json:"settings"`
+	} `json:"project"`
+	Audiences map[string]bucketingAudience `json:"audiences"`
+	Features  []bucketingFeature           `json:"features"`
+	Variables []struct {
+		ID   string `json:"_id"`
+		Key  string `json:"key"`
+		Type string `json:"type"`
+	} `json:"variables"`
+}
+
+type bucketingAudience struct {
+	ID      string         `json:"_id"`
+	Name    string         `json:"name,omitempty"`
+	Filters audienceFilter `json:"filters"`
+}
+
+// audienceFilter is either a single filter, or a group of filters combined with an operator.
+type audienceFilter struct {
+	Type        string        `json:"type"`
+	SubType     string        `json:"subType"`
+	Comparator  string        `json:"comparator"`
+	Values      []interface{} `json:"values"`
+	DataKey     string        `json:"dataKey"`
+	DataKeyType string        `json:"dataKeyType"`
+	Audiences   []string      `json:"_audiences"`
+
+	Operator string           `json:"operator"`
+	Filters  []audienceFilter `json:"filters"`
+}
+
+type bucketingFeature struct {
+	ID            string               `json:"_id"`
+	Key           string               `json:"key"`
+	Type          string               `json:"type"`
+	Variations    []bucketingVariation `json:"variations"`
+	Configuration struct {
+		Targets []bucketingTarget `json:"targets"`
+	} `json:"configuration"`
+}
+
+type bucketingVariation struct {
+	ID        string `json:"_id"`
+	Key       string `json:"key"`
+	Name      string `json:"name"`
+	Variables []struct {
+		Var   string      `json:"_var"`
+		Value interface{} `json:"value"`
+	} `json:"variables"`
+}
+
+type bucketingTarget struct {
+	ID           string            `json:"_id"`
+	Name         string            `json:"name,omitempty"`
+	Audience     bucketingAudience `json:"_audience"`
+	Distribution []struct {
+		Variation  string  `json:"_variation"`
+		Percentage float64 `json:"percentage"`
+	} `json:"distribution"`
+	Rollout      *bucketingRollout `json:"rollout"`
+	BucketingKey string            `json:"bucketingKey"`
+}
+
+type bucketingRollout struct {
+	Type            string         `json:"type"`
+	StartPercentage float64        `json:"startPercentage"`
+	StartDate       time.Time      `json:"startDate"`
+	Stages          []rolloutStage `json:"stages"`
+}
+
+type rolloutStage struct {
+	Type       string    `json:"type"`
+	Date       time.Time `json:"date"`
+	Percentage float64   `json:"percentage"`
+}
+
+func parseBucketingConfig(rawConfig []byte) (*bucketingConfig, error) {
+	var config bucketingConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *bucketingConfig) feature(key string) *bucketingFeature {
+	for f := range c.Features {
+		if c.Features[f].Key == key {
+			return &c.Features[f]
+		}
+	}
+	return nil
+}
+
+func (f *bucketingFeature) variation(id string) *bucketingVariation {
+	for v := range f.Variations {
+		if f.Variations[v].ID == id {
+			return &f.Variations[v]
+		}
+	}
+	return nil
+}
+
+// The attributes of a user that audience filters can match on, by filter subType.
+func bucketingUserAttributes(user devcycle.User, platformData devcycle.PlatformData) map[string]interface{} {
+	customData := map[string]interface{}{}
+	for key, value := range user.CustomData {
+		customData[key] = value
+	}
+	for key, value := range user.PrivateCustomData {
+		customData[key] = value
+	}
+	deviceModel := user.DeviceModel
+	if deviceModel == "" {
+		deviceModel = platformData.DeviceModel
+	}
+	return map[string]interface{}{
+		"user_id":         user.UserId,
+		"email":           user.Email,
+		"country":         user.Country,
+		"appVersion":      user.AppVersion,
+		"deviceModel":     deviceModel,
+		"platform":        platformData.Platform,
+		"platformVersion": platformData.PlatformVersion,
+		"customData":      customData,
+	}
+}
+
+// TargetEvaluation describes how a user fared against one of a feature's targeting rules.
+type TargetEvaluation struct {
+	ID                string   `json:"_id"`
+	Name              string   `json:"name,omitempty"`
+	Index             int      `json:"index"`
+	AudienceID        string   `json:"audienceId,omitempty"`
+	AudienceName      string   `json:"audienceName,omitempty"`
+	AudiencePassed    bool     `json:"audiencePassed"`
+	RolloutPercentage *float64 `json:"rolloutPercentage,omitempty"`
+	RolloutHash       *float64 `json:"rolloutHash,omitempty"`
+	RolloutPassed     *bool    `json:"rolloutPassed,omitempty"`
+}
+
+// FeatureEvaluation is the result of evaluating a feature for a user, with each targeting rule that was considered.
+type FeatureEvaluation struct {
+	Feature   string              `json:"feature"`
+	Reason    string              `json:"reason"`
+	Details   string              `json:"details,omitempty"`
+	Target    *TargetEvaluation   `json:"target,omitempty"`
+	Bucket    *float64            `json:"bucket,omitempty"`
+	Variation *EvaluatedVariation `json:"variation,omitempty"`
+	Targets   []TargetEvaluation  `json:"targets"`
+}
+
+type EvaluatedVariation struct {
+	ID   string `json:"_id"`
+	Key  string `json:"key,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// evaluateFeature runs a feature's targeting rules for a user in order, returning the variation of the first rule
+// whose audience and rollout the user passes.
+func (c *bucketingConfig) evaluateFeature(feature *bucketingFeature, user map[string]interface{}, now time.Time) FeatureEvaluation {
+	result := FeatureEvaluation{Feature: feature.Key, Reason: EvalReasonDefault, Targets: []TargetEvaluation{}}
+	for t, target := range feature.Configuration.Targets {
+		evaluation := TargetEvaluation{
+			ID:             target.ID,
+			Name:           target.Name,
+			Index:          t,
+			AudienceID:     target.Audience.ID,
+			AudienceName:   target.Audience.Name,
+			AudiencePassed: c.passesFilter(target.Audience.Filters, user),
+		}
+		if !evaluation.AudiencePassed {
+			result.Targets = append(result.Targets, evaluation)
+			continue
+		}
+		rolloutHash, bucketingHash := boundedHashes(bucketingKeyValue(target.BucketingKey, user), target.ID)
+		if target.Rollout != nil {
+			percentage := target.Rollout.currentPercentage(now)
+			passed := percentage > 0 && rolloutHash <= percentage
+			evaluation.RolloutPercentage, evaluation.RolloutHash, evaluation.RolloutPassed = &percentage, &rolloutHash, &passed
+			if !passed {
+				result.Targets = append(result.Targets, evaluation)
+				if c.Project.Settings.DisablePassthroughRollouts {
+					result.Details = DefaultDetailsNotInRollout
+					return result
+				}
+				continue
+			}
+		}
+		result.Targets = append(result.Targets, evaluation)
+		result.Target = &result.Targets[len(result.Targets)-1]
+		result.Bucket = &bucketingHash
+		variationID := target.variationFor(bucketingHash)
+		result.Variation = &EvaluatedVariation{ID: variationID}
+		if variation := feature.variation(variationID); variation != nil {
+			result.Variation.Key, result.Variation.Name = variation.Key, variation.Name
+		}
+		result.Reason = EvalReasonTargetingMatch
+		if len(target.Distribution) > 1 {
+			result.Reason = EvalReasonSplit
+		}
+		return result
+	}
+	result.Details = DefaultDetailsUserNotTargeted
+	return result
+}
+
+// Pick the variation for a bucketing hash from a target's distribution, ordered by variation ID.
+func (t bucketingTarget) variationFor(bucketingHash float64) string {
+	distribution := append(t.Distribution[:0:0], t.Distribution...)
+	sort.SliceStable(distribution, func(a, b int) bool { return distribution[a].Variation < distribution[b].Variation })
+	upper := 0.0
+	for _, d := range distribution {
+		upper += d.Percentage
+		if bucketingHash < upper {
+			return d.Variation
+		}
+	}
+	if len(distribution) > 0 {
+		return distribution[len(distribution)-1].Variation
+	}
+	return ""
+}
+
+// The share of users, from 0 to 1, a rollout includes at a point in time. Gradual rollouts are interpolated linearly
+// between stages.
+func (r *bucketingRollout) currentPercentage(now time.Time) float64 {
+	if r.Type == "schedule" {
+		if now.Before(r.StartDate) {
+			return 0
+		}
+		return 1
+	}
+	if now.Before(r.StartDate) {
+		return 0
+	}
+	currentPercentage, currentDate := r.StartPercentage, r.StartDate
+	for _, stage := range r.Stages {
+		if !stage.Date.After(now) {
+			currentPercentage, currentDate = stage.Percentage, stage.Date
+			continue
+		}
+		if stage.Type != "linear" {
+			break
+		}
+		progress := float64(now.Sub(currentDate)) / float64(stage.Date.Sub(currentDate))
+		return currentPercentage + (stage.Percentage-currentPercentage)*progress
+	}
+	return currentPercentage
+}
+
+func (c *bucketingConfig) passesFilter(filter audienceFilter, user map[string]interface{}) bool {
+	if filter.Operator != "" && filter.Type == "" {
+		if len(filter.Filters) == 0 {
+			return false
+		}
+		for _, f := range filter.Filters {
+			passed := c.passesFilter(f, user)
+			if filter.Operator == "or" && passed {
+				return true
+			}
+			if filter.Operator != "or" && !passed {
+				return false
+			}
+		}
+		return filter.Operator != "or"
+	}
+
+	switch filter.Type {
+	case "all":
+		return true
+	case "audienceMatch":
+		matched := false
+		for _, id := range filter.Audiences {
+			if audience, ok := c.Audiences[id]; ok && c.passesFilter(audience.Filters, user) {
+				matched = true
+			}
+		}
+		if filter.Comparator == "!=" {
+			return !matched
+		}
+		return matched
+	case "user":
+		return passesUserFilter(filter, user)
+	default:
+		// Includes optIn, which the proxy doesn't support
+		return false
+	}
+}
+
+func passesUserFilter(filter audienceFilter, user map[string]interface{}) bool {
+	var value interface{}
+	if filter.SubType == "customData" {
+		customData, _ := user["customData"].(map[string]interface{})
+		value = customData[filter.DataKey]
+	} else {
+		value = user[filter.SubType]
+	}
+	exists := value != nil && value != ""
+
+	switch filter.Comparator {
+	case "exist":
+		return exists
+	case "!exist":
+		return !exists
+	}
+	negated := strings.HasPrefix(filter.Comparator, "!")
+	if !exists {
+		return negated
+	}
+
+	switch {
+	case filter.SubType == "appVersion" || filter.SubType == "platformVersion":
+		return compareValues(filter, func(expected interface{}) (int, bool) {
+			return compareVersions(fmt.Sprint(value), fmt.Sprint(expected)), true
+		}, fmt.Sprint(value))
+	case filter.SubType == "customData" && filter.DataKeyType == "Number":
+		number, ok := toFloat(value)
+		if !ok {
+			return negated
+		}
+		return compareValues(filter, func(expected interface{}) (int, bool) {
+			e, ok := toFloat(expected)
+			if !ok {
+				return 0, false
+			}
+			switch {
+			case number < e:
+				return -1, true
+			case number > e:
+				return 1, true
+			}
+			return 0, true
+		}, "")
+	case filter.SubType == "customData" && filter.DataKeyType == "Boolean":
+		return compareValues(filter, func(expected interface{}) (int, bool) {
+			if fmt.Sprint(expected) == fmt.Sprint(value) {
+				return 0, true
+			}
+			return 1, true
+		}, "")
+	default:
+		return compareValues(filter, func(expected interface{}) (int, bool) {
+			return strings.Compare(fmt.Sprint(value), fmt.Sprint(expected)), true
+		}, fmt.Sprint(value))
+	}
+}
+
+// Apply a filter's comparator against each of its values. compare orders the user's value against a filter value, and
+// text is the user's value for the string comparators.
+func compareValues(filter audienceFilter, compare func(expected interface{}) (int, bool), text string) bool {
+	anyMatch := func(match func(expected interface{}) bool) bool {
+		for _, expected := range filter.Values {
+			if match(expected) {
+				return true
+			}
+		}
+		return false
+	}
+	ordered := func(test func(int) bool) bool {
+		return anyMatch(func(expected interface{}) bool {
+			result, ok := compare(expected)
+			return ok && test(result)
+		})
+	}
+	switch filter.Comparator {
+	case "=":
+		return ordered(func(r int) bool { return r == 0 })
+	case "!=":
+		return !ordered(func(r int) bool { return r == 0 })
+	case ">":
+		return ordered(func(r int) bool { return r > 0 })
+	case ">=":
+		return ordered(func(r int) bool { return r >= 0 })
+	case "<":
+		return ordered(func(r int) bool { return r < 0 })
+	case "<=":
+		return ordered(func(r int) bool { return r <= 0 })
+	case "contain":
+		return anyMatch(func(e interface{}) bool { return strings.Contains(text, fmt.Sprint(e)) })
+	case "!contain":
+		return !anyMatch(func(e interface{}) bool { return strings.Contains(text, fmt.Sprint(e)) })
+	case "startWith":
+		return anyMatch(func(e interface{}) bool { return strings.HasPrefix(text, fmt.Sprint(e)) })
+	case "!startWith":
+		return !anyMatch(func(e interface{}) bool { return strings.HasPrefix(text, fmt.Sprint(e)) })
+	case "endWith":
+		return anyMatch(func(e interface{}) bool { return strings.HasSuffix(text, fmt.Sprint(e)) })
+	case "!endWith":
+		return !anyMatch(func(e interface{}) bool { return strings.HasSuffix(text, fmt.Sprint(e)) })
+	}
+	return false
+}
+
+// Compare dotted version strings numerically, segment by segment.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// The value a target buckets users by, their user_id unless it names a custom data key.
+func bucketingKeyValue(bucketingKey string, user map[string]interface{}) string {
+	if bucketingKey == "" || bucketingKey == "user_id" {
+		return fmt.Sprint(user["user_id"])
+	}
+	if value, ok := user[bucketingKey]; ok && value != "" {
+		return fmt.Sprint(value)
+	}
+	customData, _ := user["customData"].(map[string]interface{})
+	if value, ok := customData[bucketingKey]; ok {
+		return fmt.Sprint(value)
+	}
+	return "null"
+}
+
+// The rollout and bucketing hashes of a user for a target, each between 0 and 1.
+func boundedHashes(bucketingValue, targetID string) (rolloutHash, bucketingHash float64) {
+	targetHash := murmur3.SeedStringSum32(bucketingBaseSeed, targetID)
+	rolloutHash = float64(murmur3.SeedStringSum32(targetHash, bucketingValue+"_rollout")) / maxHashValue
+	bucketingHash = float64(murmur3.SeedStringSum32(targetHash, bucketingValue)) / maxHashValue
+	return rolloutHash, bucketingHash
+}
+
+// Explanation explains the evaluation of a feature, or of a variable through the feature that serves it.
+type Explanation struct {
+	FeatureEvaluation
+	Variable string      `json:"variable,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	// The variation the evaluator chose, when it differs from the one the DevCycle client served
+	EvaluatorVariation *EvaluatedVariation `json:"evaluatorVariation,omitempty"`
+}
+
+// useServed replaces the explanation's variation and value with those the DevCycle client served, as the client is
+// the source of truth, so the evaluator only annotates the rules considered. An empty variationID means the client
+// served none. When the evaluator disagrees, its rules can't explain what was served, so they are dropped and its own
+// variation is kept as EvaluatorVariation.
+func (e *Explanation) useServed(c *bucketingConfig, variationID string, value interface{}) {
+	e.Value = value
+	if e.Feature == "" {
+		return
+	}
+	evaluated := ""
+	if e.Variation != nil {
+		evaluated = e.Variation.ID
+	}
+	if evaluated == variationID {
+		return
+	}
+	e.EvaluatorVariation = e.Variation
+	e.Reason, e.Details = EvalReasonEvaluatorMismatch, ""
+	e.Target, e.Bucket, e.Targets = nil, nil, []TargetEvaluation{}
+	e.Variation = nil
+	if variationID == "" {
+		return
+	}
+	e.Variation = &EvaluatedVariation{ID: variationID}
+	if feature := c.feature(e.Feature); feature != nil {
+		if variation := feature.variation(variationID); variation != nil {
+			e.Variation.Key, e.Variation.Name = variation.Key, variation.Name
+		}
+	}
+}
+
+// explain evaluates a variable or feature for a user, explaining which targeting rule served it and why.
+func (c *bucketingConfig) explain(variableKey, featureKey string, user map[string]interface{}, now time.Time) (Explanation, error) {
+	if featureKey != "" {
+		feature := c.feature(featureKey)
+		if feature == nil {
+			return Explanation{}, fmt.Errorf("feature not found: %s", featureKey)
+		}
+		return Explanation{FeatureEvaluation: c.evaluateFeature(feature, user, now)}, nil
+	}
+
+	explanation := Explanation{
+		FeatureEvaluation: FeatureEvaluation{Reason: EvalReasonDefault, Targets: []TargetEvaluation{}},
+		Variable:          variableKey,
+	}
+	variableID := ""
+	for _, variable := range c.Variables {
+		if variable.Key == variableKey {
+			variableID = variable.ID
+		}
+	}
+	if variableID == "" {
+		explanation.Details = DefaultDetailsMissingVariable
+		return explanation, nil
+	}
+	// Variables are served by the feature whose variations set them
+	for f := range c.Features {
+		feature := &c.Features[f]
+		if !feature.servesVariable(variableID) {
+			continue
+		}
+		explanation.FeatureEvaluation = c.evaluateFeature(feature, user, now)
+		if explanation.Variation == nil {
+			return explanation, nil
+		}
+		// A distribution can name a variation the feature no longer has, which serves nothing
+		variation := feature.variation(explanation.Variation.ID)
+		if variation == nil {
+			return explanation, nil
+		}
+		for _, variable := range variation.Variables {
+			if variable.Var == variableID {
+				explanation.Value = variable.Value
+				return explanation, nil
+			}
+		}
+		explanation.Reason, explanation.Details = EvalReasonDefault, DefaultDetailsUserNotTargeted
+		return explanation, nil
+	}
+	explanation.Details = DefaultDetailsUserNotTargeted
+	return explanation, nil
+}
+
+func (f *bucketingFeature) servesVariable(variableID string) bool {
+	for _, variation := range f.Variations {
+		for _, variable := range variation.Variables {
+			if variable.Var == variableID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const evaluatorTestConfig = `{
+	"audiences": {
+		"aud-internal": {"_id": "aud-internal", "name": "Internal", "filters": {"operator": "and", "filters": [
+			{"type": "user", "subType": "email", "comparator": "endWith", "values": ["@example.com"]}
+		]}}
+	},
+	"features": [{
+		"_id": "feat-1", "key": "checkout", "type": "release",
+		"variations": [
+			{"_id": "var-a", "key": "control", "name": "Control", "variables": [{"_var": "v-flag", "value": false}]},
+			{"_id": "var-b", "key": "treatment", "name": "Treatment", "variables": [{"_var": "v-flag", "value": true}]}
+		],
+		"configuration": {"targets": [
+			{
+				"_id": "target-internal",
+				"_audience": {"_id": "aud-1", "filters": {"operator": "and", "filters": [
+					{"type": "audienceMatch", "comparator": "=", "_audiences": ["aud-internal"]}
+				]}},
+				"distribution": [{"_variation": "var-b", "percentage": 1}]
+			},
+			{
+				"_id": "target-pro",
+				"_audience": {"_id": "aud-2", "filters": {"operator": "and", "filters": [
+					{"type": "user", "subType": "customData", "dataKey": "plan", "dataKeyType": "String", "comparator": "=", "values": ["pro"]},
+					{"type": "user", "subType": "appVersion", "comparator": ">=", "values": ["2.10"]}
+				]}},
+				"distribution": [{"_variation": "var-a", "percentage": 0.5}, {"_variation": "var-b", "percentage": 0.5}]
+			},
+			{
+				"_id": "target-rollout",
+				"_audience": {"_id": "aud-3", "filters": {"operator": "and", "filters": [{"type": "all"}]}},
+				"rollout": {"type": "schedule", "startDate": "2999-01-01T00:00:00Z"},
+				"distribution": [{"_variation": "var-b", "percentage": 1}]
+			}
+		]}
+	}],
+	"variables": [{"_id": "v-flag", "key": "new-checkout", "type": "Boolean"}, {"_id": "v-unused", "key": "unused", "type": "String"}]
+}`
+
+func TestEvaluatorExplain(t *testing.T) {
+	config, err := parseBucketingConfig([]byte(evaluatorTestConfig))
+	require.NoError(t, err)
+	now := time.Now()
+	platform := devcycle.PlatformData{Platform: "Go"}
+
+	internal := bucketingUserAttributes(devcycle.User{UserId: "u1", Email: "dev@example.com"}, platform)
+	explanation, err := config.explain("new-checkout", "", internal, now)
+	require.NoError(t, err)
+	assert.Equal(t, EvalReasonTargetingMatch, explanation.Reason)
+	assert.Equal(t, "target-internal", explanation.Target.ID)
+	assert.Equal(t, "treatment", explanation.Variation.Key)
+	assert.Equal(t, true, explanation.Value)
+
+	pro := bucketingUserAttributes(devcycle.User{UserId: "u2", AppVersion: "2.9.1", CustomData: map[string]interface{}{"plan": "pro"}}, platform)
+	explanation, err = config.explain("", "checkout", pro, now)
+	require.NoError(t, err)
+	assert.Equal(t, EvalReasonDefault, explanation.Reason)
+	require.Len(t, explanation.Targets, 3)
+	assert.False(t, explanation.Targets[1].AudiencePassed)
+	assert.False(t, *explanation.Targets[2].RolloutPassed)
+
+	pro["appVersion"] = "2.10.0"
+	explanation, err = config.explain("", "checkout", pro, now)
+	require.NoError(t, err)
+	assert.Equal(t, EvalReasonSplit, explanation.Reason)
+	assert.Equal(t, "target-pro", explanation.Target.ID)
+	require.NotNil(t, explanation.Bucket)
+
+	explanation, err = config.explain("unused", "", pro, now)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultDetailsUserNotTargeted, explanation.Details)
+	explanation, err = config.explain("missing", "", pro, now)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultDetailsMissingVariable, explanation.Details)
+	_, err = config.explain("", "missing", pro, now)
+	assert.Error(t, err)
+
+	// A distribution naming a variation the feature doesn't have serves no value
+	config.Features[0].Configuration.Targets[0].Distribution[0].Variation = "var-deleted"
+	explanation, err = config.explain("new-checkout", "", internal, now)
+	require.NoError(t, err)
+	assert.Equal(t, "var-deleted", explanation.Variation.ID)
+	assert.Nil(t, explanation.Value)
+}
+
+func TestExplanationUseServed(t *testing.T) {
+	config, err := parseBucketingConfig([]byte(evaluatorTestConfig))
+	require.NoError(t, err)
+	internal := bucketingUserAttributes(devcycle.User{UserId: "u1", Email: "dev@example.com"}, devcycle.PlatformData{})
+
+	explanation, err := config.explain("new-checkout", "", internal, time.Now())
+	require.NoError(t, err)
+	explanation.useServed(config, "var-b", true)
+	assert.Equal(t, "treatment", explanation.Variation.Key)
+	assert.Nil(t, explanation.EvaluatorVariation)
+	assert.Equal(t, EvalReasonTargetingMatch, explanation.Reason)
+	assert.NotNil(t, explanation.Target)
+
+	explanation.useServed(config, "var-a", false)
+	assert.Equal(t, "control", explanation.Variation.Key)
+	assert.Equal(t, false, explanation.Value)
+	assert.Equal(t, "treatment", explanation.EvaluatorVariation.Key)
+	assert.Equal(t, EvalReasonEvaluatorMismatch, explanation.Reason)
+	assert.Nil(t, explanation.Target)
+	assert.Nil(t, explanation.Bucket)
+	assert.Empty(t, explanation.Targets)
+
+	explanation, err = config.explain("", "checkout", internal, time.Now())
+	require.NoError(t, err)
+	explanation.useServed(config, "", nil)
+	assert.Nil(t, explanation.Variation)
+	assert.Nil(t, explanation.Target)
+	assert.Equal(t, EvalReasonEvaluatorMismatch, explanation.Reason)
+	assert.Equal(t, "var-b", explanation.EvaluatorVariation.ID)
+}
+
+// evaluatorTestConfig completed with the project and environment a DevCycle client needs to load it.
+func sdkTestConfig(t *testing.T) []byte {
+	fields := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(evaluatorTestConfig), &fields))
+	fields["project"] = map[string]interface{}{
+		"_id": "project", "key": "project", "a0_organization": "org",
+		"settings": map[string]interface{}{"edgeDB": map[string]bool{"enabled": false}, "optIn": map[string]bool{"enabled": false}},
+	}
+	fields["environment"] = map[string]string{"_id": "environment", "key": "development"}
+	fields["variableHashes"] = map[string]interface{}{}
+	rawConfig, err := json.Marshal(fields)
+	require.NoError(t, err)
+	return rawConfig
+}
+
+// The evaluator must agree with the DevCycle client's own bucketing, which it reimplements.
+func TestEvaluatorMatchesSDK(t *testing.T) {
+	rawConfig := sdkTestConfig(t)
+	config, err := parseBucketingConfig(rawConfig)
+	require.NoError(t, err)
+
+	platformData := devcycle.PlatformData{Platform: "Go", PlatformVersion: "1", SdkType: "server", SdkVersion: devcycle.VERSION, Hostname: "test"}
+	client, err := newStaticConfigClient("dvc_server_evaluator", rawConfig, platformData, time.Hour)
+	require.NoError(t, err)
+	defer client.Close()
+
+	users := []devcycle.User{
+		{UserId: "internal", Email: "dev@example.com"},
+		{UserId: "old-app", AppVersion: "2.9.1", CustomData: map[string]interface{}{"plan": "pro"}},
+		{UserId: "free", AppVersion: "2.10.0", CustomData: map[string]interface{}{"plan": "free"}},
+		{UserId: "nobody"},
+	}
+	for u := 0; u < 20; u++ {
+		users = append(users, devcycle.User{UserId: fmt.Sprintf("pro-%d", u), AppVersion: "2.10.0", CustomData: map[string]interface{}{"plan": "pro"}})
+	}
+	for _, user := range users {
+		t.Run(user.UserId, func(t *testing.T) {
+			attributes := bucketingUserAttributes(user, platformData)
+			explanation, err := config.explain("", "checkout", attributes, time.Now())
+			require.NoError(t, err)
+			features, err := client.AllFeatures(user)
+			require.NoError(t, err)
+			if feature, ok := features["checkout"]; ok {
+				require.NotNil(t, explanation.Variation)
+				assert.Equal(t, feature.Variation, explanation.Variation.ID)
+			} else {
+				assert.Nil(t, explanation.Variation)
+			}
+
+			explanation, err = config.explain("new-checkout", "", attributes, time.Now())
+			require.NoError(t, err)
+			variables, err := client.AllVariables(user)
+			require.NoError(t, err)
+			if variable, ok := variables["new-checkout"]; ok {
+				assert.Equal(t, variable.Value, explanation.Value)
+			} else {
+				assert.Nil(t, explanation.Value)
+			}
+		})
+	}
+}
+
+func TestBoundedHashes(t *testing.T) {
+	rollout, bucketing := boundedHashes("user", "target")
+	rolloutAgain, bucketingAgain := boundedHashes("user", "target")
+	assert.Equal(t, rollout, rolloutAgain)
+	assert.Equal(t, bucketing, bucketingAgain)
+	assert.NotEqual(t, rollout, bucketing)
+
+	// A 50/50 split should put roughly half of users in each variation
+	config, err := parseBucketingConfig([]byte(evaluatorTestConfig))
+	require.NoError(t, err)
+	target := config.Features[0].Configuration.Targets[1]
+	counts := map[string]int{}
+	for u := 0; u < 10000; u++ {
+		_, bucketing := boundedHashes(fmt.Sprintf("user-%d", u), target.ID)
+		require.True(t, bucketing >= 0 && bucketing <= 1)
+		counts[target.variationFor(bucketing)]++
+	}
+	assert.InDelta(t, 5000, counts["var-a"], 300)
+	assert.InDelta(t, 5000, counts["var-b"], 300)
+}
+
+func TestRolloutPercentage(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rollout := bucketingRollout{
+		Type:            "gradual",
+		StartPercentage: 0.1,
+		StartDate:       start,
+		Stages:          []rolloutStage{{Type: "linear", Date: start.Add(10 * time.Hour), Percentage: 0.6}},
+	}
+
+	assert.Equal(t, 0.0, rollout.currentPercentage(start.Add(-time.Hour)))
+	assert.InDelta(t, 0.35, rollout.currentPercentage(start.Add(5*time.Hour)), 0.0001)
+	assert.Equal(t, 0.6, rollout.currentPercentage(start.Add(11*time.Hour)))
+}
@@ -0,0 +1,316 @@
+package sdk_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type AsyncEventsConfig struct {
+	Enabled         bool  `json:"enabled,omitempty" desc:"Whether to acknowledge /v1/events/batch requests immediately and forward them in the background. Defaults to false."`
+	FlushIntervalMS int64 `json:"flushIntervalMS,omitempty" split_words:"true" desc:"The interval at which buffered batches are forwarded to the events API in milliseconds. Defaults to 1000."`
+	MaxBatchSize    int   `json:"maxBatchSize,omitempty" split_words:"true" desc:"The maximum number of events sent in a single request to the events API. Defaults to 500."`
+	MaxBufferSize   int   `json:"maxBufferSize,omitempty" split_words:"true" desc:"The maximum number of events buffered in memory before new batches are rejected with a 503. Defaults to 10000."`
+	MaxRetries      int   `json:"maxRetries,omitempty" split_words:"true" desc:"The number of times a failed request to the events API is retried before the events are dropped. Defaults to 5."`
+	RetryInitialMS  int64 `json:"retryInitialMS,omitempty" split_words:"true" desc:"The initial delay before retrying a failed request in milliseconds. Defaults to 500."`
+	RetryMaxMS      int64 `json:"retryMaxMS,omitempty" split_words:"true" desc:"The maximum delay between retries in milliseconds. Defaults to 30000."`
+}
+
+func (c *AsyncEventsConfig) Default() {
+	if !c.Enabled {
+		return
+	}
+	if c.FlushIntervalMS == 0 {
+		c.FlushIntervalMS = 1000
+	}
+	if c.MaxBatchSize == 0 {
+		c.MaxBatchSize = 500
+	}
+	if c.MaxBufferSize == 0 {
+		c.MaxBufferSize = 10000
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+	if c.RetryInitialMS == 0 {
+		c.RetryInitialMS = 500
+	}
+	if c.RetryMaxMS == 0 {
+		c.RetryMaxMS = 30000
+	}
+}
+
+var errEventBufferFull = fmt.Errorf("event buffer is full")
+
+type pendingBatch struct {
+	items  []interface{}
+	events int
+}
+
+// eventBatcher buffers /v1/events/batch items from many callers and forwards them to the events API on an interval,
+// coalescing items that share credentials into as few upstream requests as possible.
+type eventBatcher struct {
+	config       AsyncEventsConfig
+	eventsAPIURI string
+	defaultAuth  string
+	httpClient   *http.Client
+	// When set, flushed batches are handed to the disk queue for delivery instead of being sent directly.
+	queue *eventQueue
+
+	mu            sync.Mutex
+	pending       map[string]*pendingBatch
+	pendingEvents int
+	// Credentials with a delivery in progress
+	delivering map[string]bool
+	deliveries sync.WaitGroup
+
+	flushNow chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newEventBatcher(config AsyncEventsConfig, eventsAPIURI, defaultAuth string, queue *eventQueue) *eventBatcher {
+	config.Default()
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &eventBatcher{
+		config:       config,
+		eventsAPIURI: eventsAPIURI,
+		defaultAuth:  defaultAuth,
+		httpClient:   http.DefaultClient,
+		queue:        queue,
+		pending:      make(map[string]*pendingBatch),
+		delivering:   make(map[string]bool),
+		flushNow:     make(chan struct{}, 1),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// Add buffers batch items for forwarding, returning errEventBufferFull if the buffer can't take them.
+func (b *eventBatcher) Add(authorization string, items []interface{}) error {
+	events := 0
+	for _, item := range items {
+		events += countBatchItemEvents(item)
+	}
+
+	b.mu.Lock()
+	if b.pendingEvents+events > b.config.MaxBufferSize {
+		b.mu.Unlock()
+		return errEventBufferFull
+	}
+	batch, ok := b.pending[authorization]
+	if !ok {
+		batch = &pendingBatch{}
+		b.pending[authorization] = batch
+	}
+	batch.items = append(batch.items, items...)
+	batch.events += events
+	b.pendingEvents += events
+	full := batch.events >= b.config.MaxBatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *eventBatcher) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(time.Duration(b.config.FlushIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.flush(b.ctx, b.config.MaxRetries)
+		case <-b.flushNow:
+			b.flush(b.ctx, b.config.MaxRetries)
+		case <-b.ctx.Done():
+			// Deliveries still retrying give up and put their events back, then everything buffered gets a final
+			// attempt, without waiting around to retry it
+			b.deliveries.Wait()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			b.flush(ctx, 0)
+			b.deliveries.Wait()
+			cancel()
+			return
+		}
+	}
+}
+
+// Start delivering the buffered batches in the background, one goroutine per set of credentials, so a caller whose
+// batches keep failing doesn't hold up everyone else's. Credentials with a delivery still in progress are skipped, and
+// their batches keep buffering until the next flush.
+func (b *eventBatcher) flush(ctx context.Context, retries int) {
+	b.mu.Lock()
+	ready := make(map[string]*pendingBatch, len(b.pending))
+	for authorization, batch := range b.pending {
+		if b.delivering[authorization] {
+			continue
+		}
+		ready[authorization] = batch
+		delete(b.pending, authorization)
+		b.pendingEvents -= batch.events
+		b.delivering[authorization] = true
+	}
+	b.mu.Unlock()
+
+	for authorization, batch := range ready {
+		b.deliveries.Add(1)
+		go func(authorization string, batch *pendingBatch) {
+			defer b.deliveries.Done()
+			chunks := chunkBatchItems(batch.items, b.config.MaxBatchSize)
+			for n, chunk := range chunks {
+				if !b.deliver(ctx, authorization, chunk, retries) {
+					// Interrupted by shutdown, so hand the undelivered events back for the final flush
+					for _, rest := range chunks[n:] {
+						b.restore(authorization, rest)
+					}
+					break
+				}
+			}
+			b.mu.Lock()
+			delete(b.delivering, authorization)
+			b.mu.Unlock()
+		}(authorization, batch)
+	}
+}
+
+// Put undelivered batch items back in the buffer. They were already accepted, so the buffer limit doesn't apply.
+func (b *eventBatcher) restore(authorization string, items []interface{}) {
+	events := 0
+	for _, item := range items {
+		events += countBatchItemEvents(item)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.pending[authorization]
+	if !ok {
+		batch = &pendingBatch{}
+		b.pending[authorization] = batch
+	}
+	batch.items = append(items, batch.items...)
+	batch.events += events
+	b.pendingEvents += events
+}
+
+// Send a chunk of batch items, retrying failures. Returns false if ctx ended before the chunk was either delivered or
+// given up on.
+func (b *eventBatcher) deliver(ctx context.Context, authorization string, items []interface{}, retries int) bool {
+	payload, err := json.Marshal(map[string]interface{}{"batch": items})
+	if err != nil {
+		log.Printf("Error marshaling events batch: %s", err)
+		return true
+	}
+	if b.queue != nil {
+		if err := b.queue.Enqueue(authorization, payload); err != nil {
+			log.Printf("Error queueing events batch: %s", err)
+		}
+		return true
+	}
+	if authorization == "" {
+		authorization = b.defaultAuth
+	}
+	for attempt := 0; ; attempt++ {
+		status, _, err := postEventsBatch(ctx, b.httpClient, b.eventsAPIURI, authorization, payload)
+		if err == nil && !isRetryableStatus(status) {
+			if status >= http.StatusBadRequest {
+				log.Printf("Events API rejected events batch with status %d, dropping it", status)
+			}
+			return true
+		}
+		if attempt >= retries {
+			if err != nil {
+				log.Printf("Error forwarding events batch, dropping it after %d attempts: %s", attempt+1, err)
+			} else {
+				log.Printf("Events API responded with status %d, dropping events batch after %d attempts", status, attempt+1)
+			}
+			return true
+		}
+		delay := retryBackoff(attempt, time.Duration(b.config.RetryInitialMS)*time.Millisecond, time.Duration(b.config.RetryMaxMS)*time.Millisecond)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Close forwards anything still buffered and stops the background flushing.
+func (b *eventBatcher) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	return nil
+}
+
+// Split batch items into groups containing at most maxEvents events. An item is never split, so a single
+// item with more events than the limit is sent on its own.
+func chunkBatchItems(items []interface{}, maxEvents int) [][]interface{} {
+	var chunks [][]interface{}
+	var current []interface{}
+	count := 0
+	for _, item := range items {
+		events := countBatchItemEvents(item)
+		if len(current) > 0 && count+events > maxEvents {
+			chunks = append(chunks, current)
+			current = nil
+			count = 0
+		}
+		current = append(current, item)
+		count += events
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+func countBatchItemEvents(item interface{}) int {
+	batchMap, ok := item.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	events, _ := batchMap["events"].([]interface{})
+	return len(events)
+}
+
+// Check that a /v1/events/batch item has the shape the events API expects, since once it has been acknowledged
+// there is no way to report a rejection back to the caller.
+func validateBatchItem(item interface{}) error {
+	batchMap, ok := item.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("batch items must be objects")
+	}
+	user, ok := batchMap["user"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("batch item is missing 'user'")
+	}
+	if userID, ok := user["user_id"].(string); !ok || userID == "" {
+		return fmt.Errorf("batch item user is missing 'user_id'")
+	}
+	events, ok := batchMap["events"].([]interface{})
+	if !ok {
+		return fmt.Errorf("batch item is missing 'events'")
+	}
+	for _, eventInterface := range events {
+		event, ok := eventInterface.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("events must be objects")
+		}
+		if eventType, ok := event["type"].(string); !ok || eventType == "" {
+			return fmt.Errorf("event is missing 'type'")
+		}
+	}
+	return nil
+}
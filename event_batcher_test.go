@@ -0,0 +1,125 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchItem(userID string, events int) map[string]interface{} {
+	eventList := make([]interface{}, events)
+	for i := range eventList {
+		eventList[i] = map[string]interface{}{"type": "customEvent"}
+	}
+	return map[string]interface{}{
+		"user":   map[string]interface{}{"user_id": userID},
+		"events": eventList,
+	}
+}
+
+func TestEventBatcherCoalescesBatches(t *testing.T) {
+	var mu sync.Mutex
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		requests = append(requests, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	// Nothing is flushed until Close
+	batcher := newEventBatcher(AsyncEventsConfig{
+		Enabled:         true,
+		FlushIntervalMS: time.Hour.Milliseconds(),
+		MaxBatchSize:    100,
+	}, server.URL, "dvc_server_default", nil)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, batcher.Add("dvc_server_caller", []interface{}{batchItem("user", 2)}))
+	}
+	require.NoError(t, batcher.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 1)
+	assert.Len(t, requests[0]["batch"], 5)
+}
+
+func TestEventBatcherDeliversCallersIndependently(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Header.Get("Authorization")]++
+		mu.Unlock()
+		if r.Header.Get("Authorization") == "dvc_server_failing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	batcher := newEventBatcher(AsyncEventsConfig{
+		Enabled:         true,
+		FlushIntervalMS: time.Hour.Milliseconds(),
+		MaxBatchSize:    2,
+		RetryInitialMS:  time.Minute.Milliseconds(),
+		RetryMaxMS:      time.Minute.Milliseconds(),
+	}, server.URL, "", nil)
+
+	// Full batches are flushed straight away. The failing caller's batch is waiting to be retried while the other
+	// caller's is delivered.
+	require.NoError(t, batcher.Add("dvc_server_failing", []interface{}{batchItem("user", 2)}))
+	require.NoError(t, batcher.Add("dvc_server_working", []interface{}{batchItem("user", 2)}))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests["dvc_server_working"] == 1 && requests["dvc_server_failing"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Closing interrupts the retry, and the failing batch gets a final attempt
+	require.NoError(t, batcher.Close())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, requests["dvc_server_failing"])
+	assert.Equal(t, 1, requests["dvc_server_working"])
+}
+
+func TestEventBatcherRejectsWhenFull(t *testing.T) {
+	batcher := newEventBatcher(AsyncEventsConfig{
+		Enabled:         true,
+		FlushIntervalMS: time.Hour.Milliseconds(),
+		MaxBufferSize:   3,
+	}, "http://127.0.0.1:1", "", nil)
+	defer batcher.Close()
+
+	require.NoError(t, batcher.Add("", []interface{}{batchItem("user", 2)}))
+	assert.ErrorIs(t, batcher.Add("", []interface{}{batchItem("user", 2)}), errEventBufferFull)
+}
+
+func TestChunkBatchItems(t *testing.T) {
+	items := []interface{}{batchItem("a", 3), batchItem("b", 1), batchItem("c", 5), batchItem("d", 2), batchItem("e", 2)}
+	chunks := chunkBatchItems(items, 4)
+	require.Len(t, chunks, 3)
+	assert.Len(t, chunks[0], 2)
+	assert.Len(t, chunks[1], 1)
+	assert.Len(t, chunks[2], 2)
+}
+
+func TestValidateBatchItem(t *testing.T) {
+	assert.NoError(t, validateBatchItem(batchItem("user", 1)))
+	assert.EqualError(t, validateBatchItem(batchItem("", 1)), "batch item user is missing 'user_id'")
+	assert.EqualError(t, validateBatchItem(map[string]interface{}{
+		"user": map[string]interface{}{"user_id": "user"},
+	}), "batch item is missing 'events'")
+}
@@ -0,0 +1,51 @@
+package sdk_proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EventMetadataConfig struct {
+	Static         map[string]string `json:"static,omitempty" desc:"Metadata added to every tracked event, as key:value pairs e.g. region:us-east-1,cluster:prod."`
+	Headers        map[string]string `json:"headers,omitempty" desc:"Metadata keys to populate from request headers, as key:header pairs e.g. service:X-Service-Name."`
+	ClientIPKey    string            `json:"clientIPKey,omitempty" split_words:"true" desc:"When set, the caller's IP address is added to the metadata of every tracked event under this key."`
+	TrustedProxies []string          `json:"trustedProxies,omitempty" split_words:"true" desc:"IPs or CIDRs of proxies whose X-Forwarded-For and X-Real-IP headers are trusted for the caller's IP address. By default they aren't trusted, and the address of the connection is used."`
+}
+
+func (c EventMetadataConfig) Validate() error {
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+	}
+	return nil
+}
+
+// Build the metadata fields configured for this instance from the incoming request. These are applied on top of
+// whatever metadata the caller sent, so they can be relied on when slicing events by the calling service.
+func (i *ProxyInstance) requestEventMetadata(c *gin.Context) map[string]interface{} {
+	config := i.EventMetadata
+	if len(config.Static) == 0 && len(config.Headers) == 0 && config.ClientIPKey == "" {
+		return nil
+	}
+	metadata := make(map[string]interface{}, len(config.Static)+len(config.Headers)+1)
+	for key, value := range config.Static {
+		metadata[key] = value
+	}
+	for key, header := range config.Headers {
+		if value := c.GetHeader(header); value != "" {
+			metadata[key] = value
+		}
+	}
+	if config.ClientIPKey != "" {
+		if ip := c.ClientIP(); ip != "" {
+			metadata[config.ClientIPKey] = ip
+		}
+	}
+	return metadata
+}
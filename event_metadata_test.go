@@ -0,0 +1,79 @@
+package sdk_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []SinkEvent
+}
+
+func (s *recordingSink) WriteEvents(events []SinkEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestRequestEventMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, test := range []struct {
+		name           string
+		trustedProxies []string
+		clientIP       string
+	}{
+		{name: "forwarded headers ignored by default", clientIP: "10.0.0.7"},
+		{name: "forwarded headers from a trusted proxy", trustedProxies: []string{"10.0.0.0/8"}, clientIP: "203.0.113.9"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &ProxyInstance{
+				SDKKey:           "dvc_server_metadata",
+				DisableEventsAPI: true,
+				EventMetadata: EventMetadataConfig{
+					Static:         map[string]string{"region": "us-east-1"},
+					Headers:        map[string]string{"service": "X-Service-Name"},
+					ClientIPKey:    "clientIP",
+					TrustedProxies: test.trustedProxies,
+				},
+			}
+			sink := &recordingSink{}
+			instance.AddEventSink(sink)
+			r := newRouter(nil, instance)
+			request := func(path, body string) {
+				req := httptest.NewRequest("POST", path, strings.NewReader(body))
+				req.RemoteAddr = "10.0.0.7:51234"
+				req.Header.Set("Authorization", "dvc_server_metadata")
+				req.Header.Set("X-Service-Name", "checkout")
+				req.Header.Set("X-Forwarded-For", "203.0.113.9")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			}
+
+			request("/v1/track", `{"user": {"user_id": "u1"}, "events": [{"type": "customEvent", "metaData": {"region": "caller"}}]}`)
+			request("/v1/events/batch", `{"batch": [{"user": {"user_id": "u2"}, "events": [{"type": "customEvent"}]}]}`)
+
+			require.Len(t, sink.events, 2)
+			for _, event := range sink.events {
+				metadata := event.Event["metaData"].(map[string]interface{})
+				assert.Equal(t, "us-east-1", metadata["region"], "configured fields override the caller's")
+				assert.Equal(t, "checkout", metadata["service"])
+				assert.Equal(t, test.clientIP, metadata["clientIP"])
+				assert.Equal(t, Version, metadata["sdkProxy"])
+			}
+		})
+	}
+
+	assert.Error(t, EventMetadataConfig{TrustedProxies: []string{"not-an-ip"}}.Validate())
+}
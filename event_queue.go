@@ -0,0 +1,366 @@
+package sdk_proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const queuedPayloadExtension = ".json"
+
+type EventQueueConfig struct {
+	Enabled        bool   `json:"enabled,omitempty" desc:"Whether to persist outgoing events to a disk-backed queue before forwarding them to the events API. Defaults to false."`
+	Path           string `json:"path,omitempty" desc:"The directory used to store queued event payloads. Required when the event queue is enabled. Payloads are stored with the caller's SDK key in plaintext, so restrict access to it."`
+	MaxSizeBytes   int64  `json:"maxSizeBytes,omitempty" split_words:"true" desc:"The maximum total size of queued payloads on disk before the oldest are evicted. Defaults to 100MB."`
+	MaxPayloads    int    `json:"maxPayloads,omitempty" split_words:"true" desc:"The maximum number of queued payloads before the oldest are evicted. Defaults to 10000."`
+	RetryInitialMS int64  `json:"retryInitialMS,omitempty" split_words:"true" desc:"The initial delay before retrying a failed delivery in milliseconds. Defaults to 1000."`
+	RetryMaxMS     int64  `json:"retryMaxMS,omitempty" split_words:"true" desc:"The maximum delay between delivery retries in milliseconds. Defaults to 60000."`
+}
+
+func (c *EventQueueConfig) Default() {
+	if !c.Enabled {
+		return
+	}
+	if c.MaxSizeBytes == 0 {
+		c.MaxSizeBytes = 100 * 1024 * 1024
+	}
+	if c.MaxPayloads == 0 {
+		c.MaxPayloads = 10000
+	}
+	if c.RetryInitialMS == 0 {
+		c.RetryInitialMS = 1000
+	}
+	if c.RetryMaxMS == 0 {
+		c.RetryMaxMS = 60000
+	}
+}
+
+// A single events API batch payload as stored on disk, along with the credentials needed to deliver it.
+type queuedPayload struct {
+	Authorization string          `json:"authorization"`
+	Payload       json.RawMessage `json:"payload"`
+	EnqueuedAt    time.Time       `json:"enqueuedAt"`
+}
+
+type queueEntry struct {
+	seq  uint64
+	size int64
+}
+
+// eventQueue is a write-ahead queue of events API batch payloads. Every payload is written to its own file
+// before being acknowledged, and a background goroutine delivers them oldest-first, retrying with exponential
+// backoff until the events API accepts them.
+type eventQueue struct {
+	config       EventQueueConfig
+	eventsAPIURI string
+	defaultAuth  string
+	httpClient   *http.Client
+
+	mu        sync.Mutex
+	entries   []queueEntry
+	sizeBytes int64
+	nextSeq   uint64
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newEventQueue(config EventQueueConfig, eventsAPIURI, defaultAuth string) (*eventQueue, error) {
+	config.Default()
+	if config.Path == "" {
+		return nil, fmt.Errorf("event queue path must be set")
+	}
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return nil, fmt.Errorf("error creating event queue directory: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &eventQueue{
+		config:       config,
+		eventsAPIURI: eventsAPIURI,
+		defaultAuth:  defaultAuth,
+		httpClient:   http.DefaultClient,
+		wake:         make(chan struct{}, 1),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	if err := q.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if len(q.entries) > 0 {
+		log.Printf("Loaded %d queued event payloads from %s", len(q.entries), config.Path)
+	}
+	q.wg.Add(1)
+	go q.run()
+	return q, nil
+}
+
+// Rebuild the in-memory index from the payload files left behind by a previous run.
+func (q *eventQueue) load() error {
+	files, err := os.ReadDir(q.config.Path)
+	if err != nil {
+		return fmt.Errorf("error reading event queue directory: %w", err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// A write that never completed, so it was never acknowledged either
+			_ = os.Remove(filepath.Join(q.config.Path, name))
+			continue
+		}
+		if !strings.HasSuffix(name, queuedPayloadExtension) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queuedPayloadExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		q.entries = append(q.entries, queueEntry{seq: seq, size: info.Size()})
+		q.sizeBytes += info.Size()
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+	q.evict()
+	return nil
+}
+
+func (q *eventQueue) filename(seq uint64) string {
+	return filepath.Join(q.config.Path, fmt.Sprintf("%020d%s", seq, queuedPayloadExtension))
+}
+
+// Enqueue durably stores a batch payload for delivery. Once it returns without error the payload will survive a restart.
+func (q *eventQueue) Enqueue(authorization string, payload []byte) error {
+	data, err := json.Marshal(queuedPayload{
+		Authorization: authorization,
+		Payload:       payload,
+		EnqueuedAt:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if q.config.MaxSizeBytes > 0 && int64(len(data)) > q.config.MaxSizeBytes {
+		return fmt.Errorf("event payload of %d bytes exceeds the event queue size limit", len(data))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq := q.nextSeq
+	q.nextSeq++
+	name := q.filename(seq)
+	if err = writeFileSync(name+".tmp", data, 0600); err != nil {
+		_ = os.Remove(name + ".tmp")
+		return fmt.Errorf("error writing queued events: %w", err)
+	}
+	if err = os.Rename(name+".tmp", name); err != nil {
+		_ = os.Remove(name + ".tmp")
+		return fmt.Errorf("error writing queued events: %w", err)
+	}
+	// Make the rename itself durable, not just the file's contents
+	if err = syncDir(q.config.Path); err != nil {
+		return fmt.Errorf("error writing queued events: %w", err)
+	}
+	q.entries = append(q.entries, queueEntry{seq: seq, size: int64(len(data))})
+	q.sizeBytes += int64(len(data))
+	q.evict()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Write a file and flush it to disk before returning.
+func writeFileSync(name string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Flush a directory's entries to disk, so files created or renamed in it survive a power loss.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Drop the oldest payloads until the queue is back within its limits. Must be called with the lock held.
+func (q *eventQueue) evict() {
+	evicted := 0
+	for len(q.entries) > 0 &&
+		((q.config.MaxPayloads > 0 && len(q.entries) > q.config.MaxPayloads) ||
+			(q.config.MaxSizeBytes > 0 && q.sizeBytes > q.config.MaxSizeBytes)) {
+		oldest := q.entries[0]
+		q.entries = q.entries[1:]
+		q.sizeBytes -= oldest.size
+		_ = os.Remove(q.filename(oldest.seq))
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("Event queue is full, evicted %d oldest payloads", evicted)
+	}
+}
+
+// Len returns the number of payloads waiting to be delivered.
+func (q *eventQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+func (q *eventQueue) oldest() (queueEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return queueEntry{}, false
+	}
+	return q.entries[0], true
+}
+
+func (q *eventQueue) remove(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, entry := range q.entries {
+		if entry.seq == seq {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			q.sizeBytes -= entry.size
+			break
+		}
+	}
+	if err := os.Remove(q.filename(seq)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing delivered event payload: %s", err)
+	}
+}
+
+func (q *eventQueue) run() {
+	defer q.wg.Done()
+	attempt := 0
+	for {
+		entry, ok := q.oldest()
+		if !ok {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.ctx.Done():
+				return
+			}
+		}
+
+		data, err := os.ReadFile(q.filename(entry.seq))
+		if err != nil {
+			// Evicted while we were picking it up
+			q.remove(entry.seq)
+			continue
+		}
+		var payload queuedPayload
+		if err = json.Unmarshal(data, &payload); err != nil {
+			log.Printf("Dropping unreadable queued event payload %d: %s", entry.seq, err)
+			q.remove(entry.seq)
+			continue
+		}
+		authorization := payload.Authorization
+		if authorization == "" {
+			authorization = q.defaultAuth
+		}
+
+		status, _, err := postEventsBatch(q.ctx, q.httpClient, q.eventsAPIURI, authorization, payload.Payload)
+		if err == nil && !isRetryableStatus(status) {
+			if status >= http.StatusBadRequest {
+				log.Printf("Events API rejected queued event payload with status %d, dropping it", status)
+			}
+			q.remove(entry.seq)
+			attempt = 0
+			continue
+		}
+
+		delay := retryBackoff(attempt, time.Duration(q.config.RetryInitialMS)*time.Millisecond, time.Duration(q.config.RetryMaxMS)*time.Millisecond)
+		attempt++
+		if err != nil {
+			log.Printf("Error delivering queued events, retrying in %s: %s", delay, err)
+		} else {
+			log.Printf("Events API responded with status %d, retrying queued events in %s", status, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops delivery. Anything still queued stays on disk and is picked up again on the next start.
+func (q *eventQueue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+	return nil
+}
+
+// Send a batch payload to the events API, returning the response status and body.
+func postEventsBatch(ctx context.Context, httpClient *http.Client, eventsAPIURI, authorization string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", eventsAPIURI+"/v1/events/batch", bytes.NewBuffer(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// Exponential backoff starting at initial and doubling on each attempt, capped at max.
+func retryBackoff(attempt int, initial, max time.Duration) time.Duration {
+	delay := initial
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
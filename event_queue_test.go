@@ -0,0 +1,100 @@
+package sdk_proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventQueueDelivery(t *testing.T) {
+	var attempts int32
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first delivery so the queue has to retry it
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, r.Header.Get("Authorization")+" "+string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	queue, err := newEventQueue(EventQueueConfig{
+		Enabled:        true,
+		Path:           t.TempDir(),
+		RetryInitialMS: 10,
+		RetryMaxMS:     20,
+	}, server.URL, "dvc_server_default")
+	require.NoError(t, err)
+	defer queue.Close()
+
+	require.NoError(t, queue.Enqueue("dvc_server_caller", []byte(`{"batch":[1]}`)))
+	require.NoError(t, queue.Enqueue("", []byte(`{"batch":[2]}`)))
+
+	assert.Eventually(t, func() bool { return queue.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		`dvc_server_caller {"batch":[1]}`,
+		`dvc_server_default {"batch":[2]}`,
+	}, received)
+}
+
+func TestEventQueueSurvivesRestart(t *testing.T) {
+	// Nothing is listening here, so payloads stay queued
+	config := EventQueueConfig{
+		Enabled:        true,
+		Path:           t.TempDir(),
+		RetryInitialMS: 60000,
+	}
+	queue, err := newEventQueue(config, "http://127.0.0.1:1", "")
+	require.NoError(t, err)
+	require.NoError(t, queue.Enqueue("", []byte(`{"batch":[1]}`)))
+	require.NoError(t, queue.Enqueue("", []byte(`{"batch":[2]}`)))
+	require.NoError(t, queue.Close())
+
+	reopened, err := newEventQueue(config, "http://127.0.0.1:1", "")
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, 2, reopened.Len())
+	require.NoError(t, reopened.Enqueue("", []byte(`{"batch":[3]}`)))
+	assert.Equal(t, uint64(2), reopened.entries[2].seq)
+}
+
+func TestEventQueueEvictsOldest(t *testing.T) {
+	queue, err := newEventQueue(EventQueueConfig{
+		Enabled:        true,
+		Path:           t.TempDir(),
+		MaxPayloads:    2,
+		RetryInitialMS: 60000,
+	}, "http://127.0.0.1:1", "")
+	require.NoError(t, err)
+	defer queue.Close()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, queue.Enqueue("", []byte(`{"batch":[]}`)))
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	require.Len(t, queue.entries, 2)
+	assert.Equal(t, uint64(2), queue.entries[0].seq)
+	assert.Equal(t, uint64(3), queue.entries[1].seq)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, retryBackoff(0, time.Second, time.Minute))
+	assert.Equal(t, 8*time.Second, retryBackoff(3, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, retryBackoff(10, time.Second, time.Minute))
+}
@@ -0,0 +1,411 @@
+package sdk_proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	EventSinkTypeFile    = "file"
+	EventSinkTypeWebhook = "webhook"
+
+	SignatureHeader = "X-DevCycle-Proxy-Signature"
+)
+
+// EventSink receives a copy of every custom and automatic event handled by the Track and BatchEvents endpoints, and of
+// the automatic events generated by the instance's DevCycle client.
+// Implementations must be safe for concurrent use, and should not block for long as they are called from the
+// request handlers.
+type EventSink interface {
+	WriteEvents(events []SinkEvent) error
+	Close() error
+}
+
+// SinkEvent is a single event along with the user it was sent for.
+type SinkEvent struct {
+	// The endpoint that received the event, either "track" or "batch", or "sdk" for the DevCycle client's own events.
+	Source     string                 `json:"source"`
+	SDKKey     string                 `json:"sdkKey"`
+	ReceivedAt time.Time              `json:"receivedAt"`
+	User       map[string]interface{} `json:"user"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+type EventSinkConfig struct {
+	Type string `json:"type"`
+
+	// File sink options
+	Path         string `json:"path,omitempty"`
+	MaxSizeBytes int64  `json:"maxSizeBytes,omitempty"`
+	MaxBackups   int    `json:"maxBackups,omitempty"`
+
+	// Webhook sink options
+	URL              string            `json:"url,omitempty"`
+	Secret           string            `json:"secret,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	BatchSize        int               `json:"batchSize,omitempty"`
+	FlushIntervalMS  int64             `json:"flushIntervalMS,omitempty"`
+	RequestTimeoutMS int64             `json:"requestTimeoutMS,omitempty"`
+	MaxRetries       int               `json:"maxRetries,omitempty"`
+	MaxBufferSize    int               `json:"maxBufferSize,omitempty"`
+}
+
+func (c *EventSinkConfig) Default() {
+	switch c.Type {
+	case EventSinkTypeFile:
+		if c.MaxSizeBytes == 0 {
+			c.MaxSizeBytes = 100 * 1024 * 1024
+		}
+		if c.MaxBackups == 0 {
+			c.MaxBackups = 5
+		}
+	case EventSinkTypeWebhook:
+		if c.BatchSize == 0 {
+			c.BatchSize = 100
+		}
+		if c.FlushIntervalMS == 0 {
+			c.FlushIntervalMS = 5000
+		}
+		if c.RequestTimeoutMS == 0 {
+			c.RequestTimeoutMS = 10000
+		}
+		if c.MaxRetries == 0 {
+			c.MaxRetries = 3
+		}
+		if c.MaxBufferSize == 0 {
+			c.MaxBufferSize = 10000
+		}
+	}
+}
+
+func newEventSink(config EventSinkConfig) (EventSink, error) {
+	config.Default()
+	switch config.Type {
+	case EventSinkTypeFile:
+		return newFileEventSink(config)
+	case EventSinkTypeWebhook:
+		return newWebhookEventSink(config)
+	default:
+		return nil, fmt.Errorf("unknown event sink type: %q", config.Type)
+	}
+}
+
+// AddEventSink registers an additional sink to receive a copy of every event handled by this instance.
+func (i *ProxyInstance) AddEventSink(sink EventSink) {
+	i.eventSinksMu.Lock()
+	defer i.eventSinksMu.Unlock()
+	i.eventSinks = append(i.eventSinks, sink)
+}
+
+func (i *ProxyInstance) writeToEventSinks(events []SinkEvent) {
+	if len(events) == 0 {
+		return
+	}
+	i.eventSinksMu.RLock()
+	defer i.eventSinksMu.RUnlock()
+	for _, sink := range i.eventSinks {
+		if err := sink.WriteEvents(events); err != nil {
+			log.Printf("Error writing events to sink: %s", err)
+		}
+	}
+}
+
+func (i *ProxyInstance) hasEventSinks() bool {
+	i.eventSinksMu.RLock()
+	defer i.eventSinksMu.RUnlock()
+	return len(i.eventSinks) > 0
+}
+
+func (i *ProxyInstance) closeEventSinks() {
+	i.eventSinksMu.Lock()
+	defer i.eventSinksMu.Unlock()
+	for _, sink := range i.eventSinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("Error closing event sink: %s", err)
+		}
+	}
+	i.eventSinks = nil
+}
+
+// fileEventSink appends events as newline-delimited JSON, rotating the file once it reaches MaxSizeBytes.
+// Rotated files are renamed to path.1, path.2 and so on, keeping at most MaxBackups of them.
+type fileEventSink struct {
+	config EventSinkConfig
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+}
+
+func newFileEventSink(config EventSinkConfig) (*fileEventSink, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("file event sink path must be set")
+	}
+	sink := &fileEventSink{config: config}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *fileEventSink) open() error {
+	file, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening event sink file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileEventSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	for n := s.config.MaxBackups - 1; n >= 1; n-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", s.config.Path, n), fmt.Sprintf("%s.%d", s.config.Path, n+1))
+	}
+	if s.config.MaxBackups > 0 {
+		if err := os.Rename(s.config.Path, s.config.Path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.config.Path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileEventSink) WriteEvents(events []SinkEvent) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("event sink is closed")
+	}
+	if s.size > 0 && s.size+int64(buf.Len()) > s.config.MaxSizeBytes {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("error rotating event sink file: %w", err)
+		}
+	}
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileEventSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// webhookEventSink buffers events and POSTs them as {"events": [...]} to a URL, signing each body with an
+// HMAC-SHA256 of the shared secret when one is configured. While the webhook is failing, at most MaxBufferSize events
+// are buffered, dropping the oldest beyond that.
+type webhookEventSink struct {
+	config     EventSinkConfig
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	pending []SinkEvent
+	// Events dropped from a full buffer since the last flush
+	dropped int
+
+	flushNow chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newWebhookEventSink(config EventSinkConfig) (*webhookEventSink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webhook event sink url must be set")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &webhookEventSink{
+		config:     config,
+		httpClient: &http.Client{Timeout: time.Duration(config.RequestTimeoutMS) * time.Millisecond},
+		flushNow:   make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	sink.wg.Add(1)
+	go sink.run()
+	return sink, nil
+}
+
+func (s *webhookEventSink) WriteEvents(events []SinkEvent) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, events...)
+	if overflow := len(s.pending) - s.config.MaxBufferSize; s.config.MaxBufferSize > 0 && overflow > 0 {
+		s.pending = append([]SinkEvent(nil), s.pending[overflow:]...)
+		s.dropped += overflow
+	}
+	full := len(s.pending) >= s.config.BatchSize
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *webhookEventSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Duration(s.config.FlushIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(s.ctx, s.config.MaxRetries)
+		case <-s.flushNow:
+			s.flush(s.ctx, s.config.MaxRetries)
+		case <-s.ctx.Done():
+			s.flush(context.Background(), 0)
+			return
+		}
+	}
+}
+
+func (s *webhookEventSink) flush(ctx context.Context, retries int) {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("Dropped the oldest %d events for webhook sink %s, as its buffer was full", dropped, s.config.URL)
+	}
+
+	for start := 0; start < len(pending); start += s.config.BatchSize {
+		end := start + s.config.BatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		body, err := json.Marshal(map[string]interface{}{"events": pending[start:end]})
+		if err != nil {
+			log.Printf("Error marshaling webhook events: %s", err)
+			continue
+		}
+		if err = postSignedWebhook(ctx, s.httpClient, s.config.URL, s.config.Secret, s.config.Headers, body, retries); err != nil {
+			if ctx.Err() != nil {
+				// Interrupted by Close, so put the undelivered events back for the final flush
+				s.mu.Lock()
+				s.pending = append(pending[start:], s.pending...)
+				s.mu.Unlock()
+				return
+			}
+			log.Printf("Dropping %d events for webhook sink %s: %s", end-start, s.config.URL, err)
+		}
+	}
+}
+
+func (s *webhookEventSink) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// Sign a webhook body with HMAC-SHA256, in the form "sha256=<hex digest>".
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// POST a JSON body to a webhook, retrying network errors, 429 and 5xx responses with exponential backoff.
+func postSignedWebhook(ctx context.Context, httpClient *http.Client, url, secret string, headers map[string]string, body []byte, retries int) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		if secret != "" {
+			req.Header.Set(SignatureHeader, signPayload(secret, body))
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+			if !isRetryableStatus(resp.StatusCode) {
+				if resp.StatusCode >= http.StatusBadRequest {
+					return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+				}
+				return nil
+			}
+			err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+		}
+		if attempt >= retries {
+			return err
+		}
+		select {
+		case <-time.After(retryBackoff(attempt, 500*time.Millisecond, 30*time.Second)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Round-trip a value through JSON to get a generic map of its fields.
+func toJSONMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Flatten events API batch items into sink events. source is the endpoint the batch was received on.
+func batchSinkEvents(source, sdkKey string, batch []interface{}) []SinkEvent {
+	now := time.Now()
+	var events []SinkEvent
+	for _, batchItem := range batch {
+		batchMap, ok := batchItem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		user, _ := batchMap["user"].(map[string]interface{})
+		batchEvents, _ := batchMap["events"].([]interface{})
+		for _, eventInterface := range batchEvents {
+			if event, ok := eventInterface.(map[string]interface{}); ok {
+				events = append(events, SinkEvent{Source: source, SDKKey: sdkKey, ReceivedAt: now, User: user, Event: event})
+			}
+		}
+	}
+	return events
+}
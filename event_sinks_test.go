@@ -0,0 +1,139 @@
+package sdk_proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sinkEvents(ids ...string) []SinkEvent {
+	events := make([]SinkEvent, len(ids))
+	for i, id := range ids {
+		events[i] = SinkEvent{Source: "track", Event: map[string]interface{}{"target": id}}
+	}
+	return events
+}
+
+func TestFileEventSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	line, err := json.Marshal(sinkEvents("a")[0])
+	require.NoError(t, err)
+	// Room for two events per file
+	sink, err := newEventSink(EventSinkConfig{Type: EventSinkTypeFile, Path: path, MaxSizeBytes: int64(2*len(line) + 2), MaxBackups: 2})
+	require.NoError(t, err)
+
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		require.NoError(t, sink.WriteEvents(sinkEvents(id)))
+	}
+	require.NoError(t, sink.Close())
+	assert.Error(t, sink.WriteEvents(sinkEvents("h")))
+
+	targets := func(name string) []string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		var found []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var event SinkEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			found = append(found, event.Event["target"].(string))
+		}
+		return found
+	}
+	assert.Equal(t, []string{"g"}, targets(path))
+	assert.Equal(t, []string{"e", "f"}, targets(path+".1"))
+	assert.Equal(t, []string{"c", "d"}, targets(path+".2"))
+	// Only MaxBackups rotated files are kept
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+type webhookRecorder struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	headers  []http.Header
+	received []string
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	var payload struct {
+		Events []SinkEvent `json:"events"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	for _, event := range payload.Events {
+		r.received = append(r.received, event.Event["target"].(string))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestWebhookEventSink(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	sink, err := newEventSink(EventSinkConfig{
+		Type:            EventSinkTypeWebhook,
+		URL:             server.URL,
+		Secret:          "secret",
+		Headers:         map[string]string{"X-Source": "proxy"},
+		BatchSize:       2,
+		FlushIntervalMS: time.Hour.Milliseconds(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.WriteEvents(sinkEvents("a", "b", "c", "d", "e")))
+	require.NoError(t, sink.Close())
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	require.Len(t, recorder.bodies, 3, "events are sent in batches of at most batchSize")
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, recorder.received)
+	for n, body := range recorder.bodies {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), recorder.headers[n].Get(SignatureHeader))
+		assert.Equal(t, "proxy", recorder.headers[n].Get("X-Source"))
+	}
+}
+
+func TestWebhookEventSinkBufferLimit(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	sink, err := newWebhookEventSink(EventSinkConfig{
+		URL:             server.URL,
+		BatchSize:       100,
+		FlushIntervalMS: time.Hour.Milliseconds(),
+		MaxBufferSize:   3,
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.WriteEvents(sinkEvents("a", "b")))
+	require.NoError(t, sink.WriteEvents(sinkEvents("c", "d", "e")))
+	require.NoError(t, sink.Close())
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []string{"c", "d", "e"}, recorder.received, "the oldest events are dropped")
+}
+
+func TestSignPayload(t *testing.T) {
+	assert.Equal(t, "sha256=a642b59553c93e227ec0f2f38910fbf71231a2197c00899833c00478cec86f34",
+		signPayload("secret", []byte(`{"events":[]}`)))
+}
@@ -0,0 +1,110 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// eventsRelay is a loopback events API that the DevCycle client is pointed at, so that the automatic and custom events
+// it generates pass through the proxy's own handling (user redaction, event sinks, the disk queue) on their way
+// upstream, or stop at the sinks when the events API is disabled.
+type eventsRelay struct {
+	instance *ProxyInstance
+	listener net.Listener
+	server   *http.Server
+}
+
+func newEventsRelay(instance *ProxyInstance) (*eventsRelay, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	relay := &eventsRelay{
+		instance: instance,
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/events/batch", relay.handleBatch)
+	relay.server = &http.Server{Handler: mux}
+	go func() {
+		if err := relay.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error running events relay: %s", err)
+		}
+	}()
+	return relay, nil
+}
+
+func (r *eventsRelay) URL() string {
+	return "http://" + r.listener.Addr().String()
+}
+
+func (r *eventsRelay) handleBatch(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var batchEvents map[string]interface{}
+	if err = json.Unmarshal(body, &batchEvents); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if batch, ok := batchEvents["batch"].([]interface{}); ok {
+		r.instance.UserRedaction.RedactBatch(batch)
+		r.instance.writeToEventSinks(batchSinkEvents("sdk", r.instance.SDKKey, withoutCustomEvents(batch)))
+	}
+	if r.instance.DisableEventsAPI {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	body, err = json.Marshal(batchEvents)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if r.instance.eventQueue != nil {
+		if err = r.instance.eventQueue.Enqueue(authorization, body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	status, respBody, err := postEventsBatch(req.Context(), http.DefaultClient, r.instance.eventsAPIURI, authorization, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(respBody)
+}
+
+// The client's custom events all come from /v1/track, which has already written them to the sinks.
+func withoutCustomEvents(batch []interface{}) []interface{} {
+	filtered := make([]interface{}, 0, len(batch))
+	for _, batchItem := range batch {
+		batchMap, ok := batchItem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		events, _ := batchMap["events"].([]interface{})
+		automatic := make([]interface{}, 0, len(events))
+		for _, eventInterface := range events {
+			if event, ok := eventInterface.(map[string]interface{}); ok && event["type"] != "customEvent" {
+				automatic = append(automatic, event)
+			}
+		}
+		filtered = append(filtered, map[string]interface{}{"user": batchMap["user"], "events": automatic})
+	}
+	return filtered
+}
+
+func (r *eventsRelay) Close() error {
+	return r.server.Close()
+}
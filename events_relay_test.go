@@ -0,0 +1,57 @@
+package sdk_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsRelaySinks(t *testing.T) {
+	var upstream int32
+	eventsAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&upstream, 1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer eventsAPI.Close()
+
+	const batch = `{"batch": [{"user": {"user_id": "u1", "email": "u1@example.com"}, "events": [
+		{"type": "aggVariableEvaluated", "target": "new-checkout", "value": 1},
+		{"type": "customEvent", "customType": "purchase"}
+	]}]}`
+	for _, disabled := range []bool{false, true} {
+		instance := &ProxyInstance{
+			SDKKey:           "dvc_server_relay",
+			DisableEventsAPI: disabled,
+			eventsAPIURI:     eventsAPI.URL,
+			UserRedaction:    UserRedactionConfig{Drop: []string{"email"}},
+		}
+		sink := &recordingSink{}
+		instance.AddEventSink(sink)
+		relay, err := newEventsRelay(instance)
+		require.NoError(t, err)
+
+		atomic.StoreInt32(&upstream, 0)
+		resp, err := http.Post(relay.URL()+"/v1/events/batch", "application/json", strings.NewReader(batch))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		_ = relay.Close()
+
+		// Custom events reach the sinks from /v1/track, so only the automatic ones are copied here
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, "sdk", sink.events[0].Source)
+		assert.Equal(t, "aggVariableEvaluated", sink.events[0].Event["type"])
+		assert.Equal(t, "u1", sink.events[0].User["user_id"])
+		assert.NotContains(t, sink.events[0].User, "email")
+		if disabled {
+			assert.Zero(t, atomic.LoadInt32(&upstream))
+		} else {
+			assert.Equal(t, int32(1), atomic.LoadInt32(&upstream))
+		}
+	}
+}
@@ -2,11 +2,15 @@ package sdk_proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	devcycle "github.com/devcyclehq/go-server-sdk/v2"
 	devcycle_api "github.com/devcyclehq/go-server-sdk/v2/api"
@@ -14,39 +18,108 @@ import (
 )
 
 func Health(c *gin.Context) {
-	c.Status(200)
+	instance := c.Value("instance").(*ProxyInstance)
+	health := gin.H{}
+	if instance.SSEEnabled {
+		health["sse"] = instance.rebroadcasterStats.toMap()
+	}
+	if instance.configHistory != nil {
+		if pinned, ok := instance.configHistory.Pinned(); ok {
+			health["pinnedConfig"] = pinned
+		}
+	}
+	if state := instance.killSwitch.State(); state.ActivatedAt != nil {
+		health["killSwitch"] = state
+	}
+	if len(health) == 0 {
+		c.Status(200)
+		return
+	}
+	c.JSON(200, health)
 }
 
 func Variable() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
 		user := getUserFromBody(c)
 		if user == nil {
 			return
 		}
 
 		if c.Param("key") == "" {
-			variables, err := client.AllVariables(*user)
+			hookCtx := newEvaluationContext(c, EvaluationEndpointVariables, "", user)
+			if err := instance.runBeforeHooks(hookCtx); err != nil {
+				abortWithHookError(c, err)
+				return
+			}
+			variables, err := client.AllVariables(*hookCtx.User)
 			if err != nil {
 				fmt.Println(err)
+				instance.runErrorHooks(hookCtx, err)
 				c.JSON(http.StatusInternalServerError, gin.H{})
 				return
 			}
-			c.JSON(http.StatusOK, variables)
+			result := &EvaluationResult{Variables: instance.killSwitch.ApplyToVariables(variables)}
+			if err = instance.runAfterHooks(hookCtx, result); err != nil {
+				abortWithHookError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, result.Variables)
 			return
 		}
 
-		variable, err := client.Variable(*user, c.Param("key"), nil)
+		key := c.Param("key")
+		body, _ := c.Get(gin.BodyBytesKey)
+		request, err := parseVariableRequest(body.([]byte))
 		if err != nil {
-			fmt.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{})
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message":    err.Error(),
+				"statusCode": http.StatusBadRequest,
+			})
+			return
+		}
+
+		hookCtx := newEvaluationContext(c, EvaluationEndpointVariable, key, user)
+		if err = instance.runBeforeHooks(hookCtx); err != nil {
+			abortWithHookError(c, err)
+			return
+		}
+		var variable devcycle.Variable
+		value, forced, killed := instance.killSwitch.Variable(key)
+		if forced {
+			variable.BaseVariable = devcycle.BaseVariable{Key: key, Type_: variableType(value), Value: value}
+		} else if killed {
+			variable.BaseVariable.Key = key
+			variable.IsDefaulted = true
+		} else {
+			variable, err = client.Variable(*hookCtx.User, key, nil)
+			if err != nil {
+				fmt.Println(err)
+				instance.runErrorHooks(hookCtx, err)
+				c.JSON(http.StatusInternalServerError, gin.H{})
+				return
+			}
+			variable.Key = key
+		}
+		if err = instance.runAfterHooks(hookCtx, &EvaluationResult{Variable: &variable}); err != nil {
+			abortWithHookError(c, err)
 			return
 		}
-		if !variable.IsDefaulted {
+
+		if request.hasDefault {
+			var configTypes map[string]string
+			if variable.IsDefaulted {
+				if rawConfig, _, _, err := client.GetRawConfig(); err == nil {
+					configTypes, _ = configVariableTypes(rawConfig)
+				}
+			}
+			c.JSON(http.StatusOK, request.resolve(variable, killed, configTypes))
+		} else if !variable.IsDefaulted {
 			c.JSON(http.StatusOK, variable.BaseVariable)
 		} else {
 			c.JSON(http.StatusNotFound, gin.H{
-				"message":    "Variable not found for key: " + c.Param("key"),
+				"message":    "Variable not found for key: " + key,
 				"statusCode": http.StatusNotFound,
 			})
 		}
@@ -56,26 +129,54 @@ func Variable() gin.HandlerFunc {
 func Feature() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
 
 		user := getUserFromBody(c)
 		if user == nil {
 			return
 		}
-		allFeatures, err := client.AllFeatures(*user)
+		hookCtx := newEvaluationContext(c, EvaluationEndpointFeatures, "", user)
+		if err := instance.runBeforeHooks(hookCtx); err != nil {
+			abortWithHookError(c, err)
+			return
+		}
+		allFeatures, err := client.AllFeatures(*hookCtx.User)
 		if err != nil {
+			instance.runErrorHooks(hookCtx, err)
 			c.JSON(http.StatusInternalServerError, gin.H{})
 			return
 		}
-		c.JSON(http.StatusOK, allFeatures)
+		if instance.killSwitch.Active() {
+			rawConfig, _, _, err := client.GetRawConfig()
+			if err == nil {
+				allFeatures, err = instance.killSwitch.ApplyToFeatures(allFeatures, rawConfig)
+			}
+			if err != nil {
+				instance.runErrorHooks(hookCtx, err)
+				c.JSON(http.StatusInternalServerError, gin.H{})
+				return
+			}
+		}
+		result := &EvaluationResult{Features: allFeatures}
+		if err = instance.runAfterHooks(hookCtx, result); err != nil {
+			abortWithHookError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result.Features)
 	}
 }
 
 func Track() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
 		ofIdentifier := c.Request.Header.Get("X-DevCycle-OpenFeature-SDK")
+		requestMetadata := instance.requestEventMetadata(c)
 		event := getEventFromBody(c)
-		for _, e := range event.Events {
+		if event == nil {
+			return
+		}
+		for i, e := range event.Events {
 			if e.MetaData == nil {
 				e.MetaData = make(map[string]interface{})
 			}
@@ -83,8 +184,56 @@ func Track() gin.HandlerFunc {
 			if ofIdentifier != "" {
 				e.MetaData["sdkPlatform"] = ofIdentifier
 			}
+			for key, value := range requestMetadata {
+				e.MetaData[key] = value
+			}
+			event.Events[i] = e
+		}
+		if len(instance.hooks()) > 0 {
+			hookCtx := newEvaluationContext(c, EvaluationEndpointTrack, "", &event.User.User)
+			for i := range event.Events {
+				if err := instance.runTrackHooks(hookCtx, &event.Events[i]); err != nil {
+					abortWithHookError(c, err)
+					return
+				}
+			}
+			// Hooks can replace the user as well as change it
+			event.User.User = *hookCtx.User
+		}
+
+		// The DevCycle client redacts through the events relay, everything else needs the batch built here
+		if instance.hasEventSinks() || (instance.eventQueue != nil && !instance.DisableEventsAPI) {
+			batch, err := trackBatchItems(event, instance.PlatformData)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Error building events batch: " + err.Error()})
+				return
+			}
+			instance.UserRedaction.RedactBatch(batch)
+			instance.writeToEventSinks(batchSinkEvents("track", instance.SDKKey, batch))
+
+			if instance.eventQueue != nil && !instance.DisableEventsAPI {
+				payload, err := json.Marshal(map[string]interface{}{"batch": batch})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"message": "Error marshaling events batch: " + err.Error()})
+					return
+				}
+				if err = instance.eventQueue.Enqueue(c.Request.Header.Get("Authorization"), payload); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"message": "Error queueing events: " + err.Error()})
+					return
+				}
+				c.JSON(http.StatusCreated, gin.H{"message": "Successfully queued events"})
+				return
+			}
+		}
+		if instance.DisableEventsAPI {
+			c.JSON(http.StatusCreated, gin.H{"message": "Successfully received events"})
+			return
+		}
+
+		for _, e := range event.Events {
 			_, err := client.Track(event.User.User, e)
 			if err != nil {
+				instance.runErrorHooks(newEvaluationContext(c, EvaluationEndpointTrack, "", &event.User.User), err)
 				c.JSON(http.StatusInternalServerError, gin.H{})
 			}
 		}
@@ -93,7 +242,7 @@ func Track() gin.HandlerFunc {
 
 func BatchEvents() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
 
 		body, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -115,6 +264,20 @@ func BatchEvents() gin.HandlerFunc {
 			return
 		}
 
+		if !applyUserJWTToBatch(c, batchArray) {
+			return
+		}
+
+		requestMetadata := instance.requestEventMetadata(c)
+		if instance.eventBatcher != nil {
+			for _, batchItem := range batchArray {
+				if err = validateBatchItem(batchItem); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid batch: " + err.Error()})
+					return
+				}
+			}
+		}
+
 		for _, batchItem := range batchArray {
 			batchMap, ok := batchItem.(map[string]interface{})
 			if !ok {
@@ -143,21 +306,51 @@ func BatchEvents() gin.HandlerFunc {
 				}
 
 				metadata["sdkProxy"] = Version
+				for key, value := range requestMetadata {
+					metadata[key] = value
+				}
 				events[i] = event
 			}
 
 			batchMap["events"] = events
 		}
 
+		instance.UserRedaction.RedactBatch(batchArray)
+		if instance.hasEventSinks() {
+			instance.writeToEventSinks(batchSinkEvents("batch", instance.SDKKey, batchArray))
+		}
+		if instance.DisableEventsAPI {
+			c.JSON(http.StatusCreated, gin.H{"message": "Successfully received events"})
+			return
+		}
+
+		if instance.eventBatcher != nil {
+			if err = instance.eventBatcher.Add(c.Request.Header.Get("Authorization"), batchArray); err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Error buffering events: " + err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{"message": "Successfully queued events"})
+			return
+		}
+
 		modifiedBody, err := json.Marshal(batchEvents)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error marshaling modified request body: " + err.Error()})
 			return
 		}
 
+		if instance.eventQueue != nil {
+			if err = instance.eventQueue.Enqueue(c.Request.Header.Get("Authorization"), modifiedBody); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "Error queueing events: " + err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{"message": "Successfully queued events"})
+			return
+		}
+
 		// Passthrough proxy to the configured events api endpoint.
 		httpC := http.DefaultClient
-		req, err := http.NewRequest("POST", client.DevCycleOptions.EventsAPIURI+"/v1/events/batch", bytes.NewBuffer(modifiedBody))
+		req, err := http.NewRequest("POST", instance.eventsAPIURI+"/v1/events/batch", bytes.NewBuffer(modifiedBody))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating request: " + err.Error()})
 			return
@@ -179,6 +372,55 @@ func BatchEvents() gin.HandlerFunc {
 	}
 }
 
+// Convert a Track request body into events API batch items, mirroring how the SDK queues custom events.
+func trackBatchItems(body *devcycle.UserDataAndEventsBody, platformData devcycle.PlatformData) ([]interface{}, error) {
+	if body.User == nil || body.User.UserId == "" {
+		return nil, fmt.Errorf("missing user_id")
+	}
+	user, err := toJSONMap(body.User)
+	if err != nil {
+		return nil, err
+	}
+	platform, err := toJSONMap(platformData)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range platform {
+		if existing, ok := user[key]; !ok || existing == "" {
+			user[key] = value
+		}
+	}
+
+	events := make([]interface{}, 0, len(body.Events))
+	for _, e := range body.Events {
+		if e.Type_ == "" {
+			return nil, fmt.Errorf("event type is required")
+		}
+		switch e.Type_ {
+		case "customEvent", "variableEvaluated", "variableDefaulted", "aggVariableEvaluated", "aggVariableDefaulted":
+		default:
+			e.CustomType = e.Type_
+			e.Type_ = "customEvent"
+		}
+		e.UserId = body.User.UserId
+		if e.ClientDate.IsZero() {
+			e.ClientDate = time.Now()
+		}
+		event, err := toJSONMap(e)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"user":   user,
+			"events": events,
+		},
+	}, nil
+}
+
 func GetConfig(client *devcycle.Client, version ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		instance := c.Value("instance").(*ProxyInstance)
@@ -187,6 +429,14 @@ func GetConfig(client *devcycle.Client, version ...string) gin.HandlerFunc {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
+		if c.GetBool("config_mirror") {
+			configVersion := "v2"
+			if len(version) > 0 {
+				configVersion = version[0]
+			}
+			serveMirroredConfig(c, instance.configMirror, configVersion)
+			return
+		}
 		var ret, rawConfig []byte
 		var etag, lm string
 		var err error
@@ -196,6 +446,14 @@ func GetConfig(client *devcycle.Client, version ...string) gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{})
 				return
 			}
+			if instance.killSwitch.Active() {
+				etag = instance.killSwitch.ETag(etag)
+				rawConfig, err = instance.killSwitch.ApplyToConfig(rawConfig)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{})
+					return
+				}
+			}
 			if instance.SSEEnabled {
 				secure := ""
 				if instance.SSEHttps {
@@ -242,7 +500,7 @@ func GetConfig(client *devcycle.Client, version ...string) gin.HandlerFunc {
 				}
 
 				if val, ok := config["sse"]; ok {
-					path := val.(map[string]interface{})["path"].(string)
+					path := withQueryParam(val.(map[string]interface{})["path"].(string), "sdkKey", strings.TrimSuffix(c.Param("sdkKey"), ".json"))
 
 					config["sse"] = devcycle_api.SSEHost{
 						Hostname: hostname,
@@ -259,6 +517,14 @@ func GetConfig(client *devcycle.Client, version ...string) gin.HandlerFunc {
 				return
 			}
 		} else if client == nil && len(version) > 0 {
+			// The emulator only builds /config/v2 configs, and mustn't reach the real config CDN
+			if instance.emulator != nil {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"message":    "The emulator only serves /config/v2",
+					"statusCode": http.StatusNotFound,
+				})
+				return
+			}
 			ret, etag, lm = instance.BypassSDKConfig(version[0])
 		}
 		c.Header("ETag", etag)
@@ -267,10 +533,51 @@ func GetConfig(client *devcycle.Client, version ...string) gin.HandlerFunc {
 	}
 }
 
+// Add a query parameter to a path that may already have a query string.
+func withQueryParam(path, key, value string) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
 func SSE() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		instance := c.Value("instance").(*ProxyInstance)
-		instance.sseServer.Handler(instance.SDKKey).ServeHTTP(c.Writer, c.Request)
+		if instance.sseServer == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "SSE is not enabled for this instance",
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		subscribers := atomic.AddInt32(&instance.sseSubscribers, 1)
+		defer atomic.AddInt32(&instance.sseSubscribers, -1)
+		if instance.SSEMaxSubscribers > 0 && subscribers > instance.SSEMaxSubscribers {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"message":    "Too many SSE connections",
+				"statusCode": http.StatusServiceUnavailable,
+			})
+			return
+		}
+
+		var w http.ResponseWriter = c.Writer
+		if instance.SSERetryMS > 0 {
+			w = &sseResponseWriter{ResponseWriter: c.Writer, retryMS: instance.SSERetryMS}
+		}
+		req := c.Request
+		if instance.ctx != nil {
+			// End the stream when the instance shuts down, rather than holding up its graceful shutdown
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			defer context.AfterFunc(instance.ctx, cancel)()
+			req = req.WithContext(ctx)
+		}
+		instance.sseServer.Handler(instance.SDKKey).ServeHTTP(w, req)
 	}
 }
 
@@ -293,6 +600,8 @@ func getUserFromBody(c *gin.Context) *devcycle.User {
 		return nil
 	}
 	defer c.Request.Body.Close()
+	// Kept for handlers that read other fields from the body
+	c.Set(gin.BodyBytesKey, jsonBody)
 	err = json.Unmarshal(jsonBody, &user)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
@@ -303,6 +612,12 @@ func getUserFromBody(c *gin.Context) *devcycle.User {
 		})
 		return nil
 	}
+	if !applyUserJWT(c, &user) {
+		return nil
+	}
+	if instance, ok := c.Value("instance").(*ProxyInstance); ok {
+		instance.applyUserProfile(&user)
+	}
 	return &user
 }
 
@@ -327,5 +642,24 @@ func getEventFromBody(c *gin.Context) *devcycle.UserDataAndEventsBody {
 		})
 		return nil
 	}
+	if event.User == nil {
+		// Required tokens are still checked, and can supply the user the body left out
+		var user devcycle.User
+		if !applyUserJWT(c, &user) {
+			return nil
+		}
+		if user.UserId != "" {
+			event.User = &devcycle.PopulatedUser{User: user}
+		}
+	} else if !applyUserJWT(c, &event.User.User) {
+		return nil
+	}
+	if event.User == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message":    "Missing user",
+			"statusCode": http.StatusBadRequest,
+		})
+		return nil
+	}
 	return &event
 }
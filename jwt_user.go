@@ -0,0 +1,451 @@
+package sdk_proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// How far a token's exp and nbf claims may be off from the proxy's clock.
+const jwtClockSkew = time.Minute
+
+type JWTUserConfig struct {
+	JWKSFile         string            `json:"jwksFile,omitempty" envconfig:"JWKS_FILE" desc:"The path to a JWKS file of keys that user JWTs may be signed with. Setting it or pemFile enables building users from JWTs."`
+	PEMFile          string            `json:"pemFile,omitempty" envconfig:"PEM_FILE" desc:"The path to a PEM file of public keys or certificates that user JWTs may be signed with."`
+	Issuer           string            `json:"issuer,omitempty" desc:"The iss claim user JWTs must have. Not checked if unset."`
+	Audience         string            `json:"audience,omitempty" desc:"The audience user JWTs must be issued for. Not checked if unset."`
+	Header           string            `json:"header,omitempty" desc:"The request header carrying the user JWT, optionally as a bearer token. Defaults to X-User-Authorization."`
+	Required         bool              `json:"required,omitempty" desc:"Whether requests without a user JWT are rejected, rather than using the user in the body. Defaults to false."`
+	IgnoreBody       bool              `json:"ignoreBody,omitempty" split_words:"true" desc:"Whether to build the user only from the JWT, ignoring the user in the body. Defaults to false, where the JWT's claims override the body's fields."`
+	UserIDClaim      string            `json:"userIdClaim,omitempty" split_words:"true" desc:"The claim holding the user ID. Defaults to sub."`
+	EmailClaim       string            `json:"emailClaim,omitempty" split_words:"true" desc:"The claim holding the user's email. Defaults to email."`
+	CountryClaim     string            `json:"countryClaim,omitempty" split_words:"true" desc:"The claim holding the user's country. Defaults to country."`
+	CustomDataClaims map[string]string `json:"customDataClaims,omitempty" split_words:"true" desc:"Claims copied into the user's customData, as customData key to claim name."`
+}
+
+func (c JWTUserConfig) Enabled() bool {
+	return c.JWKSFile != "" || c.PEMFile != ""
+}
+
+func (c *JWTUserConfig) Default() {
+	if !c.Enabled() {
+		return
+	}
+	if c.Header == "" {
+		c.Header = "X-User-Authorization"
+	}
+	if c.UserIDClaim == "" {
+		c.UserIDClaim = "sub"
+	}
+	if c.EmailClaim == "" {
+		c.EmailClaim = "email"
+	}
+	if c.CountryClaim == "" {
+		c.CountryClaim = "country"
+	}
+}
+
+// Apply a verified token's claims to a user, overriding the fields they map to.
+func (c JWTUserConfig) applyClaims(user *devcycle.User, claims map[string]interface{}) error {
+	userID, _ := claims[c.UserIDClaim].(string)
+	if userID == "" {
+		return fmt.Errorf("the token has no %s claim", c.UserIDClaim)
+	}
+	user.UserId = userID
+	if email, ok := claims[c.EmailClaim].(string); ok {
+		user.Email = email
+	}
+	if country, ok := claims[c.CountryClaim].(string); ok {
+		user.Country = country
+	}
+	for key, claim := range c.CustomDataClaims {
+		value, ok := claims[claim]
+		if !ok {
+			continue
+		}
+		customData := make(map[string]interface{}, len(user.CustomData)+1)
+		for k, v := range user.CustomData {
+			customData[k] = v
+		}
+		customData[key] = value
+		user.CustomData = customData
+	}
+	return nil
+}
+
+// Apply a verified token's claims to a user from an events batch, the JSON counterpart of applyClaims.
+func (c JWTUserConfig) applyClaimsToMap(user map[string]interface{}, claims map[string]interface{}) error {
+	userID, _ := claims[c.UserIDClaim].(string)
+	if userID == "" {
+		return fmt.Errorf("the token has no %s claim", c.UserIDClaim)
+	}
+	user["user_id"] = userID
+	if email, ok := claims[c.EmailClaim].(string); ok {
+		user["email"] = email
+	}
+	if country, ok := claims[c.CountryClaim].(string); ok {
+		user["country"] = country
+	}
+	for key, claim := range c.CustomDataClaims {
+		value, ok := claims[claim]
+		if !ok {
+			continue
+		}
+		existing, _ := user["customData"].(map[string]interface{})
+		customData := make(map[string]interface{}, len(existing)+1)
+		for k, v := range existing {
+			customData[k] = v
+		}
+		customData[key] = value
+		user["customData"] = customData
+	}
+	return nil
+}
+
+type jwtKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// jwtVerifier verifies RSA and ECDSA signed JWTs against a fixed set of keys. Only asymmetric algorithms with keys
+// configured up front are accepted, which the standard library covers without taking on a JWT dependency.
+type jwtVerifier struct {
+	config JWTUserConfig
+	keys   []jwtKey
+	now    func() time.Time
+}
+
+func newJWTVerifier(config JWTUserConfig) (*jwtVerifier, error) {
+	config.Default()
+	v := &jwtVerifier{config: config, now: time.Now}
+	if config.JWKSFile != "" {
+		data, err := os.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS file %s: %v", config.JWKSFile, err)
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if config.PEMFile != "" {
+		data, err := os.ReadFile(config.PEMFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parsePEMKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM file %s: %v", config.PEMFile, err)
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no keys found for verifying user JWTs")
+	}
+	return v, nil
+}
+
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make([]jwtKey, 0, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+			}
+			exponent := 0
+			for _, b := range e {
+				exponent = exponent<<8 | int(b)
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}})
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("unsupported curve %q for key %q", k.Crv, k.Kid)
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, key: key})
+		}
+	}
+	return keys, nil
+}
+
+func parsePEMKeys(data []byte) ([]jwtKey, error) {
+	var keys []jwtKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return keys, nil
+		}
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, jwtKey{key: key})
+		default:
+			return nil, fmt.Errorf("unsupported %s key type %T", block.Type, key)
+		}
+	}
+}
+
+// Verify a token's signature and registered claims, returning its claims.
+func (v *jwtVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	if err = v.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("the token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtClockSkew)) {
+		return nil, fmt.Errorf("the token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-jwtClockSkew)) {
+		return nil, fmt.Errorf("the token is not valid yet")
+	}
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return nil, fmt.Errorf("the token was not issued by %s", v.config.Issuer)
+	}
+	if v.config.Audience != "" && !jwtAudienceContains(claims["aud"], v.config.Audience) {
+		return nil, fmt.Errorf("the token is not for audience %s", v.config.Audience)
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) verifySignature(alg, kid, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	for _, k := range v.keys {
+		if kid != "" && k.kid != "" && k.kid != kid {
+			continue
+		}
+		switch key := k.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("invalid token signature")
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// The aud claim is either a single audience or a list of them.
+func jwtAudienceContains(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Merge the claims of the request's user JWT, if the instance verifies them, into a user. Returns false after
+// rejecting the request if the token is missing but required, or invalid.
+func applyUserJWT(c *gin.Context, user *devcycle.User) bool {
+	instance, ok := c.Value("instance").(*ProxyInstance)
+	if !ok || instance.jwtVerifier == nil {
+		return true
+	}
+	if err := instance.jwtVerifier.applyToUser(c.Request, user); err != nil {
+		abortWithJWTError(c, err)
+		return false
+	}
+	return true
+}
+
+// Merge the claims of the request's user JWT into every user of an events batch, like applyUserJWT.
+func applyUserJWTToBatch(c *gin.Context, batch []interface{}) bool {
+	instance, ok := c.Value("instance").(*ProxyInstance)
+	if !ok || instance.jwtVerifier == nil {
+		return true
+	}
+	if err := instance.jwtVerifier.applyToBatch(c.Request, batch); err != nil {
+		abortWithJWTError(c, err)
+		return false
+	}
+	return true
+}
+
+func abortWithJWTError(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"message":    "Invalid user JWT",
+		"exception":  err.Error(),
+		"statusCode": http.StatusUnauthorized,
+	})
+}
+
+// Verify the request's user JWT, returning nil claims if there is none and it isn't required.
+func (v *jwtVerifier) requestClaims(req *http.Request) (map[string]interface{}, error) {
+	token := strings.TrimPrefix(req.Header.Get(v.config.Header), "Bearer ")
+	if token == "" {
+		if v.config.Required {
+			return nil, fmt.Errorf("missing %s header", v.config.Header)
+		}
+		return nil, nil
+	}
+	return v.Verify(token)
+}
+
+func (v *jwtVerifier) applyToUser(req *http.Request, user *devcycle.User) error {
+	claims, err := v.requestClaims(req)
+	if err != nil || claims == nil {
+		return err
+	}
+	if v.config.IgnoreBody {
+		*user = devcycle.User{}
+	}
+	return v.config.applyClaims(user, claims)
+}
+
+// The fields of a batch user that ignoreBody drops, leaving the SDK's platform data.
+var jwtBatchUserFields = []string{"user_id", "email", "name", "language", "country", "appVersion", "appBuild", "customData", "privateCustomData"}
+
+func (v *jwtVerifier) applyToBatch(req *http.Request, batch []interface{}) error {
+	claims, err := v.requestClaims(req)
+	if err != nil || claims == nil {
+		return err
+	}
+	for _, batchItem := range batch {
+		batchMap, ok := batchItem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		user, ok := batchMap["user"].(map[string]interface{})
+		if !ok {
+			user = make(map[string]interface{})
+			batchMap["user"] = user
+		}
+		if v.config.IgnoreBody {
+			for _, field := range jwtBatchUserFields {
+				delete(user, field)
+			}
+		}
+		if err = v.config.applyClaimsToMap(user, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package sdk_proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeTestJWTKeys(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) JWTUserConfig {
+	dir := t.TempDir()
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pemFile := filepath.Join(dir, "keys.pem")
+	require.NoError(t, os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": "ec-1",
+			"use": "sig",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+	jwksFile := filepath.Join(dir, "jwks.json")
+	require.NoError(t, os.WriteFile(jwksFile, jwks, 0o600))
+
+	return JWTUserConfig{
+		JWKSFile:         jwksFile,
+		PEMFile:          pemFile,
+		Issuer:           "https://auth.example.com",
+		Audience:         "sdk-proxy",
+		CustomDataClaims: map[string]string{"plan": "https://example.com/plan"},
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	verifier, err := newJWTVerifier(writeTestJWTKeys(t, rsaKey, ecKey))
+	require.NoError(t, err)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "jwt-user",
+			"iss": "https://auth.example.com",
+			"aud": []string{"other", "sdk-proxy"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{name: "RSA key from PEM file", token: signTestJWT(t, "RS256", "", rsaKey, claims(nil))},
+		{name: "EC key from JWKS file", token: signTestJWT(t, "ES256", "ec-1", ecKey, claims(nil))},
+		{name: "unknown key", token: signTestJWT(t, "RS256", "", otherKey, claims(nil)), err: "signature"},
+		{name: "unknown kid", token: signTestJWT(t, "ES256", "ec-2", ecKey, claims(nil)), err: "signature"},
+		{name: "unsupported algorithm", token: signTestJWT(t, "HS256", "", rsaKey, claims(nil)), err: "unsupported signing algorithm"},
+		{name: "expired", token: signTestJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), err: "expired"},
+		{name: "no expiry", token: signTestJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"exp": nil})), err: "no exp claim"},
+		{name: "within clock skew", token: signTestJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}))},
+		{name: "not valid yet", token: signTestJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), err: "not valid yet"},
+		{name: "wrong issuer", token: signTestJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example.com"})), err: "not issued by"},
+		{name: "wrong audience", token: signTestJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"aud": "other"})), err: "audience"},
+		{name: "malformed", token: "not-a-jwt", err: "malformed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := verifier.Verify(test.token)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "jwt-user", got["sub"])
+		})
+	}
+
+	tampered := strings.Split(signTestJWT(t, "RS256", "", rsaKey, claims(nil)), ".")
+	forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "someone-else"}))
+	tampered[1] = base64.RawURLEncoding.EncodeToString(forged)
+	_, err = verifier.Verify(strings.Join(tampered, "."))
+	assert.Error(t, err, "a token with changed claims must not verify")
+
+	_, err = newJWTVerifier(JWTUserConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestGetUserFromBodyWithJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	config := writeTestJWTKeys(t, rsaKey, ecKey)
+
+	token := signTestJWT(t, "RS256", "", rsaKey, map[string]interface{}{
+		"sub":                      "jwt-user",
+		"email":                    "jwt@example.com",
+		"iss":                      "https://auth.example.com",
+		"aud":                      "sdk-proxy",
+		"exp":                      time.Now().Add(time.Hour).Unix(),
+		"https://example.com/plan": "enterprise",
+	})
+	body := `{"user_id":"body-user","name":"Body Name","customData":{"team":"a"}}`
+
+	tests := []struct {
+		name     string
+		config   func(c *JWTUserConfig)
+		header   string
+		status   int
+		userID   string
+		userName string
+	}{
+		{name: "claims override the body", header: "Bearer " + token, userID: "jwt-user", userName: "Body Name"},
+		{name: "no token uses the body", userID: "body-user", userName: "Body Name"},
+		{name: "no token when required", config: func(c *JWTUserConfig) { c.Required = true }, status: http.StatusUnauthorized},
+		{name: "invalid token", header: "Bearer " + token + "x", status: http.StatusUnauthorized},
+		{name: "body ignored", config: func(c *JWTUserConfig) { c.IgnoreBody = true }, header: token, userID: "jwt-user"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userConfig := config
+			if test.config != nil {
+				test.config(&userConfig)
+			}
+			verifier, err := newJWTVerifier(userConfig)
+			require.NoError(t, err)
+			instance := &ProxyInstance{jwtVerifier: verifier}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/variables", strings.NewReader(body))
+			if test.header != "" {
+				c.Request.Header.Set("X-User-Authorization", test.header)
+			}
+			c.Set("instance", instance)
+
+			user := getUserFromBody(c)
+			if test.status != 0 {
+				assert.Nil(t, user)
+				assert.Equal(t, test.status, w.Code)
+				return
+			}
+			require.NotNil(t, user)
+			assert.Equal(t, test.userID, user.UserId)
+			assert.Equal(t, test.userName, user.Name)
+			if test.userID == "jwt-user" {
+				assert.Equal(t, "jwt@example.com", user.Email)
+				assert.Equal(t, "enterprise", user.CustomData["plan"])
+			}
+		})
+	}
+}
+
+func TestEventsWithJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	config := writeTestJWTKeys(t, rsaKey, ecKey)
+	config.IgnoreBody = true
+	optional, err := newJWTVerifier(config)
+	require.NoError(t, err)
+	config.Required = true
+	required, err := newJWTVerifier(config)
+	require.NoError(t, err)
+	token := signTestJWT(t, "RS256", "", rsaKey, map[string]interface{}{
+		"sub": "jwt-user",
+		"iss": "https://auth.example.com",
+		"aud": "sdk-proxy",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name     string
+		optional bool
+		path     string
+		body     string
+		header   string
+		status   int
+	}{
+		{name: "track without a user or token", path: "/v1/track", body: `{"events": [{"type": "customEvent"}]}`, status: http.StatusUnauthorized},
+		{name: "track without a user or optional token", optional: true, path: "/v1/track", body: `{"events": [{"type": "customEvent"}]}`, status: http.StatusBadRequest},
+		{name: "track with the user from the token", path: "/v1/track", body: `{"events": [{"type": "customEvent"}]}`, header: token, status: http.StatusCreated},
+		{name: "batch without a token", path: "/v1/events/batch", body: `{"batch": [{"user": {"user_id": "body-user"}, "events": [{"type": "customEvent"}]}]}`, status: http.StatusUnauthorized},
+		{name: "batch with a token", path: "/v1/events/batch", body: `{"batch": [{"user": {"user_id": "body-user", "name": "Body Name", "platform": "iOS"}, "events": [{"type": "customEvent"}]}]}`, header: "Bearer " + token, status: http.StatusCreated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &ProxyInstance{
+				SDKKey:           "dvc_server_jwt",
+				DisableEventsAPI: true,
+				jwtVerifier:      required,
+			}
+			if test.optional {
+				instance.jwtVerifier = optional
+			}
+			sink := &recordingSink{}
+			instance.AddEventSink(sink)
+			r := newRouter(nil, instance)
+
+			req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			req.Header.Set("Authorization", "dvc_server_jwt")
+			if test.header != "" {
+				req.Header.Set("X-User-Authorization", test.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, test.status, w.Code)
+			if test.status != http.StatusCreated {
+				assert.Empty(t, sink.events)
+				return
+			}
+			require.Len(t, sink.events, 1)
+			assert.Equal(t, "jwt-user", sink.events[0].User["user_id"])
+			assert.NotContains(t, sink.events[0].User, "name")
+			if test.path == "/v1/events/batch" {
+				assert.Equal(t, "iOS", sink.events[0].User["platform"])
+			}
+		})
+	}
+}
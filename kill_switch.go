@@ -0,0 +1,317 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+)
+
+// KillSwitchAll matches every variable in a kill switch.
+const KillSwitchAll = "*"
+
+type KillSwitchConfig struct {
+	Variables []string               `json:"variables,omitempty" desc:"Keys of variables forced to their default value, or * for every variable."`
+	Values    map[string]interface{} `json:"values,omitempty" ignored:"true"`
+}
+
+func (c KillSwitchConfig) active() bool {
+	return len(c.Variables) > 0 || len(c.Values) > 0
+}
+
+func (c KillSwitchConfig) all() bool {
+	for _, key := range c.Variables {
+		if key == KillSwitchAll {
+			return true
+		}
+	}
+	return false
+}
+
+func (c KillSwitchConfig) defaulted(key string) bool {
+	for _, killed := range c.Variables {
+		if killed == key || killed == KillSwitchAll {
+			return true
+		}
+	}
+	return false
+}
+
+// KillSwitchState is the kill switch in effect on an instance.
+type KillSwitchState struct {
+	KillSwitchConfig
+	ActivatedAt *time.Time `json:"activatedAt,omitempty"`
+}
+
+// killSwitch forces variables to their defaulted state, or to a fixed value, in the instance's evaluations and the
+// config it serves to SDKs, overriding whatever the config says. Variables forced to a value keep it over being
+// defaulted.
+type killSwitch struct {
+	mu          sync.RWMutex
+	state       KillSwitchState
+	nextID      int
+	subscribers map[int]func()
+}
+
+func newKillSwitch(config KillSwitchConfig) *killSwitch {
+	k := &killSwitch{subscribers: make(map[int]func())}
+	k.Set(config)
+	return k
+}
+
+// Subscribe registers a function called each time the kill switch is set or cleared, so evaluations made before can
+// be redone. The returned function removes the subscription.
+func (k *killSwitch) Subscribe(fn func()) func() {
+	if k == nil {
+		return func() {}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := k.nextID
+	k.nextID++
+	k.subscribers[id] = fn
+	return func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		delete(k.subscribers, id)
+	}
+}
+
+// Set replaces the kill switch in effect, clearing it if config is empty.
+func (k *killSwitch) Set(config KillSwitchConfig) {
+	k.mu.Lock()
+	k.set(config)
+	subscribers := make([]func(), 0, len(k.subscribers))
+	for _, fn := range k.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	k.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn()
+	}
+}
+
+// Must be called with the lock held.
+func (k *killSwitch) set(config KillSwitchConfig) {
+	if !config.active() {
+		if k.state.ActivatedAt != nil {
+			log.Printf("Kill switch deactivated")
+		}
+		k.state = KillSwitchState{}
+		return
+	}
+	now := time.Now().UTC()
+	k.state = KillSwitchState{KillSwitchConfig: config, ActivatedAt: &now}
+	forced := make([]string, 0, len(config.Values))
+	for key := range config.Values {
+		forced = append(forced, key)
+	}
+	log.Printf("Kill switch activated, defaulting variables [%s] and forcing values of [%s]",
+		strings.Join(config.Variables, ", "), strings.Join(forced, ", "))
+}
+
+func (k *killSwitch) State() KillSwitchState {
+	if k == nil {
+		return KillSwitchState{}
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.state
+}
+
+func (k *killSwitch) Active() bool {
+	return k.State().ActivatedAt != nil
+}
+
+// Variable returns whether a variable is forced to a value, and whether it is forced to be defaulted.
+func (k *killSwitch) Variable(key string) (value interface{}, forced, defaulted bool) {
+	state := k.State()
+	if value, ok := state.Values[key]; ok {
+		return value, true, false
+	}
+	return nil, false, state.defaulted(key)
+}
+
+// ApplyToVariables removes defaulted variables from an evaluation of all variables, and sets forced values.
+func (k *killSwitch) ApplyToVariables(variables map[string]devcycle.ReadOnlyVariable) map[string]devcycle.ReadOnlyVariable {
+	state := k.State()
+	if state.ActivatedAt == nil {
+		return variables
+	}
+	result := make(map[string]devcycle.ReadOnlyVariable, len(variables))
+	for key, variable := range variables {
+		if !state.defaulted(key) {
+			result[key] = variable
+		}
+	}
+	for key, value := range state.Values {
+		variable := result[key]
+		variable.Key = key
+		variable.Type_ = variableType(value)
+		variable.Value = value
+		result[key] = variable
+	}
+	return result
+}
+
+// ApplyToFeatures removes features from an evaluation of all features when the variation served has a defaulted
+// variable, since the user no longer gets that variation.
+func (k *killSwitch) ApplyToFeatures(features map[string]devcycle.Feature, rawConfig []byte) (map[string]devcycle.Feature, error) {
+	state := k.State()
+	if state.ActivatedAt == nil {
+		return features, nil
+	}
+	var config killSwitchConfigBody
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	variableKeys := config.variableKeys()
+	result := make(map[string]devcycle.Feature, len(features))
+	for key, feature := range features {
+		killed := false
+		for _, configFeature := range config.Features {
+			if configFeature.Key != key {
+				continue
+			}
+			for _, variation := range configFeature.Variations {
+				if variation.ID != feature.Variation {
+					continue
+				}
+				for _, variable := range variation.Variables {
+					if _, forced := state.Values[variableKeys[variable.Var]]; !forced && state.defaulted(variableKeys[variable.Var]) {
+						killed = true
+					}
+				}
+			}
+		}
+		if !killed {
+			result[key] = feature
+		}
+	}
+	return result, nil
+}
+
+// ApplyToConfig rewrites a config so that SDKs using it get the kill switch's results. Defaulted variables are removed
+// from every variation, or every feature is removed when all variables are defaulted, and forced values replace the
+// values in every variation. Forced values therefore only reach users that some feature targets.
+func (k *killSwitch) ApplyToConfig(rawConfig []byte) ([]byte, error) {
+	state := k.State()
+	if state.ActivatedAt == nil {
+		return rawConfig, nil
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	if state.all() && len(state.Values) == 0 {
+		config["features"] = []interface{}{}
+		return json.Marshal(config)
+	}
+
+	variableKeys := map[string]string{}
+	variables, _ := config["variables"].([]interface{})
+	for _, v := range variables {
+		if variable, ok := v.(map[string]interface{}); ok {
+			id, _ := variable["_id"].(string)
+			key, _ := variable["key"].(string)
+			variableKeys[id] = key
+		}
+	}
+	features, _ := config["features"].([]interface{})
+	for _, f := range features {
+		feature, _ := f.(map[string]interface{})
+		variations, _ := feature["variations"].([]interface{})
+		for _, v := range variations {
+			variation, _ := v.(map[string]interface{})
+			variationVariables, _ := variation["variables"].([]interface{})
+			kept := make([]interface{}, 0, len(variationVariables))
+			for _, vv := range variationVariables {
+				variable, _ := vv.(map[string]interface{})
+				id, _ := variable["_var"].(string)
+				key := variableKeys[id]
+				if value, ok := state.Values[key]; ok {
+					variable["value"] = value
+				} else if state.defaulted(key) {
+					continue
+				}
+				kept = append(kept, variable)
+			}
+			if variation != nil {
+				variation["variables"] = kept
+			}
+		}
+	}
+	return json.Marshal(config)
+}
+
+// ETag returns the ETag to serve for a config the kill switch has been applied to, so that SDKs don't mistake it for
+// the original.
+func (k *killSwitch) ETag(etag string) string {
+	state := k.State()
+	if state.ActivatedAt == nil {
+		return etag
+	}
+	return fmt.Sprintf(`"%s-killswitch-%d"`, strings.Trim(etag, `"`), state.ActivatedAt.UnixNano())
+}
+
+// The parts of a config needed to tell which variables a variation serves.
+type killSwitchConfigBody struct {
+	Features []struct {
+		Key        string `json:"key"`
+		Variations []struct {
+			ID        string `json:"_id"`
+			Variables []struct {
+				Var string `json:"_var"`
+			} `json:"variables"`
+		} `json:"variations"`
+	} `json:"features"`
+	Variables []struct {
+		ID  string `json:"_id"`
+		Key string `json:"key"`
+	} `json:"variables"`
+}
+
+func (c killSwitchConfigBody) variableKeys() map[string]string {
+	keys := make(map[string]string, len(c.Variables))
+	for _, variable := range c.Variables {
+		keys[variable.ID] = variable.Key
+	}
+	return keys
+}
+
+// The DevCycle variable type of a JSON value.
+func variableType(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return VariableTypeBoolean
+	case float64, float32, int, int64, int32:
+		return VariableTypeNumber
+	case string:
+		return VariableTypeString
+	default:
+		return VariableTypeJSON
+	}
+}
+
+// Activate or replace the instance's kill switch, publishing a config update so SSE subscribers pick it up straight away.
+func (i *ProxyInstance) setKillSwitch(config KillSwitchConfig) {
+	i.killSwitch.Set(config)
+	if i.sseServer == nil || i.dvcClient == nil {
+		return
+	}
+	_, etag, lastModified, err := i.dvcClient.GetRawConfig()
+	if err != nil {
+		return
+	}
+	data, err := refetchConfigEvent(watchedConfig{ETag: i.killSwitch.ETag(etag), LastModified: lastModified})
+	if err != nil {
+		log.Printf("Error creating config update SSE event: %s", err)
+		return
+	}
+	i.publishSSE("", data)
+}
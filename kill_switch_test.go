@@ -0,0 +1,100 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const killSwitchTestConfig = `{
+	"features": [
+		{"key": "checkout", "variations": [
+			{"_id": "v1", "variables": [{"_var": "var-flag", "value": true}, {"_var": "var-color", "value": "red"}]},
+			{"_id": "v2", "variables": [{"_var": "var-color", "value": "blue"}]}
+		]}
+	],
+	"variables": [
+		{"_id": "var-flag", "key": "new-checkout", "type": "Boolean"},
+		{"_id": "var-color", "key": "button-color", "type": "String"}
+	]
+}`
+
+func TestKillSwitchVariables(t *testing.T) {
+	k := newKillSwitch(KillSwitchConfig{Variables: []string{"new-checkout"}, Values: map[string]interface{}{"button-color": "grey"}})
+	require.True(t, k.Active())
+
+	variables := k.ApplyToVariables(map[string]devcycle.ReadOnlyVariable{
+		"new-checkout": {BaseVariable: devcycle.BaseVariable{Key: "new-checkout", Type_: "Boolean", Value: true}},
+		"other":        {BaseVariable: devcycle.BaseVariable{Key: "other", Type_: "Number", Value: 1.0}},
+	})
+	assert.NotContains(t, variables, "new-checkout")
+	assert.Contains(t, variables, "other")
+	assert.Equal(t, devcycle.BaseVariable{Key: "button-color", Type_: "String", Value: "grey"}, variables["button-color"].BaseVariable)
+
+	value, forced, defaulted := k.Variable("button-color")
+	assert.Equal(t, "grey", value)
+	assert.True(t, forced)
+	assert.False(t, defaulted)
+	_, forced, defaulted = k.Variable("new-checkout")
+	assert.False(t, forced)
+	assert.True(t, defaulted)
+
+	k.Set(KillSwitchConfig{})
+	assert.False(t, k.Active())
+	assert.Equal(t, `"abc"`, k.ETag(`"abc"`))
+}
+
+func TestKillSwitchSubscribe(t *testing.T) {
+	k := newKillSwitch(KillSwitchConfig{})
+	changes := 0
+	unsubscribe := k.Subscribe(func() {
+		assert.True(t, k.Active(), "subscribers see the new state")
+		changes++
+	})
+	k.Set(KillSwitchConfig{Variables: []string{"new-checkout"}})
+	assert.Equal(t, 1, changes)
+	unsubscribe()
+	k.Set(KillSwitchConfig{})
+	assert.Equal(t, 1, changes)
+
+	var missing *killSwitch
+	missing.Subscribe(func() {})()
+}
+
+func TestKillSwitchFeatures(t *testing.T) {
+	k := newKillSwitch(KillSwitchConfig{Variables: []string{"new-checkout"}})
+	features, err := k.ApplyToFeatures(map[string]devcycle.Feature{
+		"checkout": {Key: "checkout", Variation: "v1"},
+	}, []byte(killSwitchTestConfig))
+	require.NoError(t, err)
+	assert.Empty(t, features)
+
+	features, err = k.ApplyToFeatures(map[string]devcycle.Feature{
+		"checkout": {Key: "checkout", Variation: "v2"},
+	}, []byte(killSwitchTestConfig))
+	require.NoError(t, err)
+	assert.Contains(t, features, "checkout")
+}
+
+func TestKillSwitchConfig(t *testing.T) {
+	k := newKillSwitch(KillSwitchConfig{Variables: []string{"new-checkout"}, Values: map[string]interface{}{"button-color": "grey"}})
+	rawConfig, err := k.ApplyToConfig([]byte(killSwitchTestConfig))
+	require.NoError(t, err)
+
+	var config killSwitchConfigBody
+	require.NoError(t, json.Unmarshal(rawConfig, &config))
+	require.Len(t, config.Features[0].Variations, 2)
+	assert.Len(t, config.Features[0].Variations[0].Variables, 1)
+	assert.Contains(t, string(rawConfig), `"value":"grey"`)
+	assert.NotContains(t, string(rawConfig), `"value":"red"`)
+
+	k.Set(KillSwitchConfig{Variables: []string{KillSwitchAll}})
+	rawConfig, err = k.ApplyToConfig([]byte(killSwitchTestConfig))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(rawConfig, &config))
+	assert.Empty(t, config.Features)
+	assert.NotEqual(t, `"abc"`, k.ETag(`"abc"`))
+}
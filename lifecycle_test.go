@@ -0,0 +1,117 @@
+package sdk_proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An instance using a loopback config server for both its config and events, so it never reaches DevCycle.
+func lifecycleTestInstance(t *testing.T, port int) *ProxyInstance {
+	server, err := newStaticConfigServer(sdkTestConfig(t), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	instance := &ProxyInstance{
+		SDKKey:      "dvc_server_lifecycle",
+		HTTPEnabled: port != 0,
+		HTTPPort:    port,
+		SSEEnabled:  true,
+		SSEHostname: "localhost",
+		SDKConfig:   SDKConfig{ConfigCDNURI: server.URL(), EventsAPIURI: server.URL()},
+	}
+	instance.Default()
+	return instance
+}
+
+func TestInstanceHandler(t *testing.T) {
+	instance := lifecycleTestInstance(t, 0)
+	handler, err := instance.Handler()
+	require.NoError(t, err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, instance.Shutdown(context.Background()))
+}
+
+func TestInstanceStartAndShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// The port is taken, so the error comes back from Start rather than only being logged
+	instance := lifecycleTestInstance(t, port)
+	err = instance.Start(context.Background())
+	assert.ErrorContains(t, err, "error starting HTTP server")
+	_ = listener.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, instance.Start(cancelled), context.Canceled)
+
+	require.NoError(t, instance.Start(context.Background()))
+	url := "http://127.0.0.1:" + strconv.Itoa(port)
+	// Without keep-alives, so the client can't leave a spare connection open that the shutdown waits on
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(url + "/healthz")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	stream, err := client.Get(url + "/event-stream?sdkKey=dvc_server_lifecycle")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	assert.Equal(t, http.StatusOK, stream.StatusCode)
+	ended := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+		}
+		close(ended)
+	}()
+
+	// Open streams don't hold up the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	assert.NoError(t, instance.Shutdown(ctx))
+	assert.Less(t, time.Since(started), 4*time.Second)
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Error("SSE stream was not ended by the shutdown")
+	}
+	_, err = client.Get(url + "/healthz")
+	assert.Error(t, err)
+
+	// A shut down instance can't be started or served again
+	assert.ErrorContains(t, instance.Start(context.Background()), "closed")
+	_, err = instance.Handler()
+	assert.Error(t, err)
+	assert.NoError(t, instance.Close())
+}
+
+func TestInstanceSetupFailure(t *testing.T) {
+	instance := lifecycleTestInstance(t, 0)
+	instance.EventQueue = EventQueueConfig{Enabled: true, Path: t.TempDir()}
+	instance.JWTUser = JWTUserConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}
+	_, err := instance.Handler()
+	require.ErrorContains(t, err, "JWT")
+
+	// What was started before the failure is closed again
+	assert.ErrorIs(t, instance.ctx.Err(), context.Canceled)
+	assert.ErrorIs(t, instance.eventQueue.ctx.Err(), context.Canceled)
+	assert.ErrorContains(t, instance.Start(context.Background()), "closed")
+}
@@ -1,6 +1,7 @@
 package sdk_proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/devcyclehq/go-server-sdk/v2/api"
@@ -37,14 +39,66 @@ type ProxyInstance struct {
 	SSEEndpointUseHeaders bool                  `json:"SSEEndpointUseHeaders" envconfig:"SSE_ENDPOINT_USE_HEADERS" default:"false" desc:"Whether to use the X-Forwarded... or Host headers to respond with the SSEHostname. Defaults to false."`
 	SSEHttps              bool                  `json:"sseHTTPS" envconfig:"SSE_HTTPS" default:"false" desc:"Whether to use HTTPS scheme for SSE connections. Defaults to false."`
 	SSEPort               int                   `json:"ssePort" envconfig:"SSE_PORT" desc:"The port to provide to clients to connect to for SSE requests. If not set, defaults to the same port as the HTTP server."`
-	SDKKey                string                `json:"sdkKey" required:"true" envconfig:"SDK_KEY" desc:"The Server SDK key to use for this instance."`
+	SSEReplayMaxEvents    int                   `json:"sseReplayMaxEvents,omitempty" envconfig:"SSE_REPLAY_MAX_EVENTS" desc:"The number of SSE events kept per channel for replaying to clients that reconnect with a Last-Event-ID. Defaults to 100."`
+	SSEReplayMaxAgeMS     int64                 `json:"sseReplayMaxAgeMS,omitempty" envconfig:"SSE_REPLAY_MAX_AGE_MS" desc:"How long SSE events are kept for replaying in milliseconds. Defaults to 300000."`
+	SSEHeartbeatMS        int64                 `json:"sseHeartbeatMS,omitempty" envconfig:"SSE_HEARTBEAT_MS" desc:"The interval at which keepalive comments are sent to SSE clients in milliseconds. Set to a negative value to disable. Defaults to 30000."`
+	SSERetryMS            int64                 `json:"sseRetryMS,omitempty" envconfig:"SSE_RETRY_MS" desc:"The reconnection delay sent to SSE clients in a retry: field in milliseconds. Not sent if unset."`
+	SSEMaxConnectionMS    int64                 `json:"sseMaxConnectionMS,omitempty" envconfig:"SSE_MAX_CONNECTION_MS" desc:"The maximum lifetime of an SSE connection in milliseconds, after which it is closed so the client reconnects. Unlimited if unset."`
+	SSEMaxSubscribers     int32                 `json:"sseMaxSubscribers,omitempty" envconfig:"SSE_MAX_SUBSCRIBERS" desc:"The maximum number of concurrent SSE connections, beyond which new connections get a 503. Unlimited if unset."`
+	SSEClientEvents       bool                  `json:"sseClientEvents,omitempty" envconfig:"SSE_PUBLISH_CLIENT_EVENTS" desc:"Whether to publish upstream SSE connection, error and config update events to SSE clients as named events. Defaults to false."`
+	SSEPollingFallback    bool                  `json:"ssePollingFallback,omitempty" envconfig:"SSE_POLLING_FALLBACK" desc:"Whether to publish config updates to SSE clients when the proxy's config changes without an update arriving over the upstream SSE connection. Defaults to false."`
+	SDKKey                string                `json:"sdkKey" envconfig:"SDK_KEY" desc:"The Server SDK key to use for this instance. Required unless running the emulator."`
+	AdminToken            string                `json:"adminToken,omitempty" envconfig:"ADMIN_TOKEN" desc:"The bearer token required on the /admin API. The admin API is disabled if unset."`
+	PermittedTokens       []string              `json:"permittedTokens,omitempty" envconfig:"PERMITTED_TOKENS" desc:"Additional tokens accepted in place of the SDK key on the /config and /event-stream routes."`
 	LogFile               string                `json:"logFile" default:"" envconfig:"LOG_FILE" desc:"The path to the log file."`
 	PlatformData          devcycle.PlatformData `json:"platformData" required:"true"`
 	SDKConfig             SDKConfig             `json:"sdkConfig" required:"true"`
+	EventQueue            EventQueueConfig      `json:"eventQueue" envconfig:"EVENT_QUEUE"`
+	AsyncEvents           AsyncEventsConfig     `json:"asyncEvents" envconfig:"ASYNC_EVENTS"`
+	EventSinks            []EventSinkConfig     `json:"eventSinks,omitempty" ignored:"true"`
+	EventMetadata         EventMetadataConfig   `json:"eventMetadata" envconfig:"EVENT_METADATA"`
+	UserRedaction         UserRedactionConfig   `json:"userRedaction" envconfig:"USER_REDACTION"`
+	ConfigMirror          ConfigMirrorConfig    `json:"configMirror" envconfig:"CONFIG_MIRROR"`
+	ConfigWebhooks        []ConfigWebhookConfig `json:"configWebhooks,omitempty" ignored:"true"`
+	ConfigHistory         ConfigHistoryConfig   `json:"configHistory" envconfig:"CONFIG_HISTORY"`
+	KillSwitch            KillSwitchConfig      `json:"killSwitch" envconfig:"KILL_SWITCH"`
+	Emulator              EmulatorConfig        `json:"emulator" envconfig:"EMULATOR"`
+	JWTUser               JWTUserConfig         `json:"jwtUser" envconfig:"JWT_USER"`
+	UserProfiles          UserProfilesConfig    `json:"userProfiles" envconfig:"USER_PROFILES"`
+	DisableEventsAPI      bool                  `json:"disableEventsAPI" envconfig:"DISABLE_EVENTS_API" desc:"Whether to stop forwarding events from /v1/track, /v1/events/batch and the DevCycle client to the events API, leaving only the configured event sinks. Defaults to false."`
 	dvcClient             *devcycle.Client
 	sseServer             *eventsource.Server
 	sseEvents             chan api.ClientEvent
+	sseRepository         *sseRepository
+	sseSubscribers        int32
+	rebroadcasterStats    rebroadcasterStats
+	sseAnnouncements      sseAnnouncements
+	configWatcher         *configWatcher
+	configWebhooks        []*configWebhook
+	configHistory         *configHistory
+	killSwitch            *killSwitch
 	bypassConfig          []byte
+	eventQueue            *eventQueue
+	eventBatcher          *eventBatcher
+	eventSinks            []EventSink
+	eventSinksMu          sync.RWMutex
+	evaluationHooks       []EvaluationHook
+	evaluationHooksMu     sync.RWMutex
+	eventsRelay           *eventsRelay
+	configMirror          *configMirror
+	emulator              *emulator
+	jwtVerifier           *jwtVerifier
+	userProfiles          *userProfileStore
+	router                *gin.Engine
+	servers               []*http.Server
+	serveErrors           []error
+	closed                bool
+	serversMu             sync.Mutex
+	// The upstream events API, which the DevCycle client may not be talking to directly
+	eventsAPIURI string
+	// Cancelled on Close to stop the instance's background goroutines
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type SDKConfig struct {
@@ -59,8 +113,46 @@ type SDKConfig struct {
 	EventsAPIURI                 string `json:"eventsAPIURI,omitempty" envconfig:"EVENTS_API_URI" desc:"The URI of the Events API - leave unspecified if not needing an outbound proxy."`
 }
 
+// Close stops the instance straight away, including its listeners, and releases its resources. Use Shutdown to wait
+// for in-flight requests first.
 func (i *ProxyInstance) Close() error {
-	return i.dvcClient.Close()
+	i.serversMu.Lock()
+	closed := i.closed
+	i.closed = true
+	i.serversMu.Unlock()
+	if closed {
+		return nil
+	}
+	if i.cancel != nil {
+		i.cancel()
+	}
+	i.closeServers()
+	// The batcher hands its final flush to the queue, so it has to stop first
+	if i.eventBatcher != nil {
+		_ = i.eventBatcher.Close()
+	}
+	// Closing the client flushes its events through the relay and queue, so they are closed after it
+	var err error
+	if i.dvcClient != nil {
+		err = i.dvcClient.Close()
+	}
+	if i.eventsRelay != nil {
+		_ = i.eventsRelay.Close()
+	}
+	if i.configHistory != nil {
+		_ = i.configHistory.Close()
+	}
+	if i.emulator != nil {
+		_ = i.emulator.Close()
+	}
+	if i.eventQueue != nil {
+		_ = i.eventQueue.Close()
+	}
+	if i.userProfiles != nil {
+		i.userProfiles.Close()
+	}
+	i.closeEventSinks()
+	return err
 }
 
 func (i *ProxyInstance) BuildDevCycleOptions() *devcycle.Options {
@@ -91,7 +183,11 @@ func (i *ProxyInstance) BuildDevCycleOptions() *devcycle.Options {
 
 func (i *ProxyInstance) BypassSDKConfig(version string) (config []byte, etag, lastModified string) {
 
-	request, err := http.NewRequest("GET", fmt.Sprintf("https://config-cdn.devcycle.com/config/%s/server/%s.json", version, i.SDKKey), nil)
+	configCDNURI := i.SDKConfig.ConfigCDNURI
+	if configCDNURI == "" {
+		configCDNURI = "https://config-cdn.devcycle.com"
+	}
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s/config/%s/server/%s.json", configCDNURI, version, i.SDKKey), nil)
 	if err != nil {
 		return i.bypassConfig, "", ""
 	}
@@ -120,20 +216,26 @@ func (i *ProxyInstance) BypassSDKConfig(version string) (config []byte, etag, la
 	return i.bypassConfig, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
 }
 
-func (i *ProxyInstance) EventRebroadcaster() {
-	for event := range i.sseEvents {
-		if event.EventType == api.ClientEventType_InternalSSEConnected {
-			log.Printf("Connected to DevCycle SSE for rebroadcasting.\n")
-		}
-		if event.EventType == api.ClientEventType_RealtimeUpdates {
-			i.sseServer.Publish([]string{i.SDKKey}, event.EventData.(eventsource.Event))
-			log.Printf("Rebroadcasting SSE event: %s\n", event.EventData.(eventsource.Event).Data())
-		}
-	}
+// Publish an event to this instance's SSE subscribers, recording it for replay to clients that reconnect.
+func (i *ProxyInstance) publishSSE(name, data string) {
+	event := i.sseRepository.Add(i.SDKKey, name, data)
+	i.sseServer.Publish([]string{i.SDKKey}, event)
 }
 
 func (i *ProxyInstance) Default() {
 	i.SDKConfig.Default()
+	i.EventQueue.Default()
+	i.AsyncEvents.Default()
+	i.ConfigMirror.Default()
+	i.ConfigHistory.Default()
+	i.JWTUser.Default()
+	i.UserProfiles.Default()
+	for s := range i.EventSinks {
+		i.EventSinks[s].Default()
+	}
+	for w := range i.ConfigWebhooks {
+		i.ConfigWebhooks[w].Default()
+	}
 	if i.HTTPEnabled && i.HTTPPort == 0 {
 		i.HTTPPort = 8080
 	}
@@ -215,6 +317,10 @@ func ParseConfig(configPath string) (*ProxyConfig, error) {
 		if err != nil {
 			return nil, err
 		}
+		// The SDK key is only optional for the emulator, so envconfig can't enforce it
+		if fullEnvConfig.SDKKey == "" && !fullEnvConfig.Emulator.Enabled() {
+			return nil, fmt.Errorf("required key SDK_KEY missing value")
+		}
 		proxyConfig.Instances = append(proxyConfig.Instances, &fullEnvConfig.ProxyInstance)
 	} else {
 		// Load config from JSON file
@@ -73,6 +73,9 @@ func TestParseConfig(t *testing.T) {
 				"SDKCONFIG_FLUSH_EVENT_QUEUE_SIZE":          "456",
 				"SDKCONFIG_CONFIG_CDN_URI":                  "https://example.com/config",
 				"SDKCONFIG_EVENTS_API_URI":                  "https://example.com/events",
+				"EVENT_METADATA_STATIC":                     "region:us-east-1,cluster:prod",
+				"EVENT_METADATA_HEADERS":                    "service:X-Service-Name",
+				"EVENT_METADATA_CLIENT_IP_KEY":              "clientIP",
 			},
 			expected: &ProxyConfig{
 				Instances: []*ProxyInstance{
@@ -104,6 +107,11 @@ func TestParseConfig(t *testing.T) {
 							ConfigCDNURI:                 "https://example.com/config",
 							EventsAPIURI:                 "https://example.com/events",
 						},
+						EventMetadata: EventMetadataConfig{
+							Static:      map[string]string{"region": "us-east-1", "cluster": "prod"},
+							Headers:     map[string]string{"service": "X-Service-Name"},
+							ClientIPKey: "clientIP",
+						},
 					},
 				},
 			},
@@ -1,8 +1,12 @@
 package sdk_proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
@@ -14,86 +18,300 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// NewBucketingProxyInstance sets up an instance and starts serving it over HTTP and/or a Unix socket, as configured.
 func NewBucketingProxyInstance(instance *ProxyInstance) (*ProxyInstance, error) {
+	if err := instance.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// setup creates the instance's DevCycle client and the services around it, and builds its router, without serving
+// anything. It does nothing once it has succeeded. Should it fail part way, the instance is closed, stopping whatever
+// it had started.
+func (i *ProxyInstance) setup() (err error) {
+	i.serversMu.Lock()
+	closed := i.closed
+	i.serversMu.Unlock()
+	if closed {
+		return fmt.Errorf("instance has been closed")
+	}
+	if i.router != nil {
+		return nil
+	}
 	gin.DisableConsoleColor()
-	if instance.LogFile != "" {
-		logFile, err := os.OpenFile(instance.LogFile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	if i.LogFile != "" {
+		logFile, err := os.OpenFile(i.LogFile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
 		if err != nil {
 			_ = fmt.Errorf("error opening log file: %s", err)
-			return nil, err
+			return err
 		}
 		gin.DefaultWriter = logFile
 		log.SetOutput(logFile)
 	} else {
 		log.SetOutput(os.Stdout)
 	}
-	if instance.SSEEnabled {
-		instance.sseEvents = make(chan api.ClientEvent, 100)
-		instance.sseServer = eventsource.NewServer()
-		instance.sseServer.ReplayAll = false
-		eventsource.NewSliceRepository()
-		go instance.EventRebroadcaster()
-		if instance.SSEHostname == "" {
+	if i.SDKKey == "" {
+		if !i.Emulator.Enabled() {
+			return fmt.Errorf("SDK key must be set")
+		}
+		i.SDKKey = EmulatorSDKKey
+	}
+	i.ctx, i.cancel = context.WithCancel(context.Background())
+	defer func() {
+		if err != nil {
+			_ = i.Close()
+		}
+	}()
+	if i.SSEEnabled {
+		i.sseEvents = make(chan api.ClientEvent, 100)
+		i.sseServer = eventsource.NewServer()
+		i.sseServer.ReplayAll = false
+		if i.SSEReplayMaxEvents == 0 {
+			i.SSEReplayMaxEvents = 100
+		}
+		if i.SSEReplayMaxAgeMS == 0 {
+			i.SSEReplayMaxAgeMS = 300000
+		}
+		i.sseRepository = newSSERepository(i.SSEReplayMaxEvents, time.Duration(i.SSEReplayMaxAgeMS)*time.Millisecond)
+		i.sseServer.Register(i.SDKKey, i.sseRepository)
+		i.sseServer.MaxConnTime = time.Duration(i.SSEMaxConnectionMS) * time.Millisecond
+		if i.SSEHeartbeatMS == 0 {
+			i.SSEHeartbeatMS = 30000
+		}
+		if i.SSEHeartbeatMS > 0 {
+			go i.sseHeartbeat(i.ctx, time.Duration(i.SSEHeartbeatMS)*time.Millisecond)
+		}
+		go i.EventRebroadcaster()
+		if i.SSEHostname == "" {
 			name, err := os.Hostname()
 			if err != nil {
-				instance.SSEHostname = "localhost"
+				i.SSEHostname = "localhost"
 			} else {
-				instance.SSEHostname = name
+				i.SSEHostname = name
 			}
-			if instance.SSEEndpointUseHeaders {
-				instance.SSEHostname = "DYNAMIC-REQUEST-HOST"
+			if i.SSEEndpointUseHeaders {
+				i.SSEHostname = "DYNAMIC-REQUEST-HOST"
 			}
 		}
-		log.Printf("Initialized SSE server at %s", instance.SSEHostname)
+		log.Printf("Initialized SSE server at %s", i.SSEHostname)
+	}
+
+	options := i.BuildDevCycleOptions()
+
+	if i.Emulator.Enabled() {
+		// The emulator stands in for both the config CDN and the events API
+		i.emulator, err = newEmulator(i.Emulator)
+		if err != nil {
+			return fmt.Errorf("error starting emulator: %v", err)
+		}
+		options.ConfigCDNURI = i.emulator.URL()
+		options.EventsAPIURI = i.emulator.URL()
+		options.ConfigPollingIntervalMS = emulatorWatchInterval
+		options.DisableRealtimeUpdates = true
+		go i.emulator.watch(i.ctx, emulatorWatchInterval)
+	}
+	i.eventsAPIURI = options.EventsAPIURI
+
+	if i.EventQueue.Enabled {
+		i.eventQueue, err = newEventQueue(i.EventQueue, i.eventsAPIURI, i.SDKKey)
+		if err != nil {
+			return fmt.Errorf("error creating event queue: %v", err)
+		}
+		log.Printf("Queueing events on disk at %s", i.EventQueue.Path)
+	}
+	if err = i.EventMetadata.Validate(); err != nil {
+		return fmt.Errorf("invalid event metadata config: %v", err)
+	}
+	for _, sinkConfig := range i.EventSinks {
+		sink, err := newEventSink(sinkConfig)
+		if err != nil {
+			return fmt.Errorf("error creating event sink: %v", err)
+		}
+		i.AddEventSink(sink)
+	}
+	if i.UserRedaction.Enabled() {
+		if err = i.UserRedaction.Validate(); err != nil {
+			return fmt.Errorf("invalid user redaction config: %v", err)
+		}
+	}
+	if i.UserRedaction.Enabled() || i.hasEventSinks() || i.DisableEventsAPI {
+		// Route the SDK's own events through the relay so they are redacted, copied to the sinks and kept from the
+		// events API when it's disabled, like those sent to /v1/track and /v1/events/batch
+		i.eventsRelay, err = newEventsRelay(i)
+		if err != nil {
+			return fmt.Errorf("error creating events relay: %v", err)
+		}
+		options.EventsAPIURI = i.eventsRelay.URL()
+	}
+	configCDNURI := options.ConfigCDNURI
+	if i.ConfigHistory.Enabled {
+		// Fetch configs through the history, so they are recorded and the instance can be pinned to one of them
+		i.configHistory, err = newConfigHistory(i.ConfigHistory, configCDNURI, options.RequestTimeout)
+		if err != nil {
+			return fmt.Errorf("error creating config history: %v", err)
+		}
+		options.ConfigCDNURI = i.configHistory.URL()
 	}
 
-	options := instance.BuildDevCycleOptions()
-	client, err := devcycle.NewClient(instance.SDKKey, options)
+	client, err := devcycle.NewClient(i.SDKKey, options)
 	if err != nil {
-		return nil, fmt.Errorf("error creating DevCycle client: %v", err)
+		return fmt.Errorf("error creating DevCycle client: %v", err)
+	}
+	i.dvcClient = client
+	i.killSwitch = newKillSwitch(i.KillSwitch)
+	if i.JWTUser.Enabled() {
+		i.jwtVerifier, err = newJWTVerifier(i.JWTUser)
+		if err != nil {
+			return fmt.Errorf("error loading user JWT keys: %v", err)
+		}
+	}
+	if i.UserProfiles.Enabled {
+		i.userProfiles, err = newUserProfileStore(i.ctx, i.UserProfiles)
+		if err != nil {
+			return fmt.Errorf("error creating user profile store: %v", err)
+		}
+	}
+	i.configWatcher = newConfigWatcher(client.GetRawConfig, configWatchInterval)
+	// The emulator has no upstream SSE connection, so its config changes are only seen by polling
+	if i.SSEEnabled && (i.SSEPollingFallback || i.emulator != nil) {
+		i.configWatcher.Subscribe(i.publishConfigChange)
+	}
+	for _, webhookConfig := range i.ConfigWebhooks {
+		webhook, err := newConfigWebhook(i.ctx, webhookConfig)
+		if err != nil {
+			return fmt.Errorf("error creating config webhook: %v", err)
+		}
+		i.configWebhooks = append(i.configWebhooks, webhook)
+	}
+	if len(i.configWebhooks) > 0 {
+		i.configWatcher.Subscribe(i.notifyConfigWebhooks)
+	}
+	go i.configWatcher.run(i.ctx)
+
+	if i.ConfigMirror.Enabled {
+		i.configMirror = newConfigMirror(i.ctx, i.ConfigMirror, configCDNURI, options.RequestTimeout)
+	}
+	if i.AsyncEvents.Enabled {
+		i.eventBatcher = newEventBatcher(i.AsyncEvents, i.eventsAPIURI, i.SDKKey, i.eventQueue)
+	}
+
+	i.router = newRouter(client, i)
+	return nil
+}
+
+// Handler returns the instance's routes, for serving from a server the caller manages rather than the instance's own
+// listeners. It sets the instance up if that hasn't been done already, so the caller must call Shutdown or Close
+// when done with it.
+func (i *ProxyInstance) Handler() (http.Handler, error) {
+	if err := i.setup(); err != nil {
+		return nil, err
 	}
-	instance.dvcClient = client
+	return i.router.Handler(), nil
+}
 
-	r := newRouter(client, instance)
+// Start sets the instance up if that hasn't been done already, and serves it over HTTP and/or a Unix socket, as
+// configured. It returns once the listeners are open, or with the error preventing it; ctx only bounds opening them.
+// Errors serving afterwards are returned by Shutdown.
+func (i *ProxyInstance) Start(ctx context.Context) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = i.setup(); err != nil {
+		return err
+	}
+	defer func() {
+		// Don't leave half the listeners running
+		if err != nil {
+			i.closeServers()
+		}
+	}()
 
-	if instance.HTTPEnabled {
-		if instance.HTTPPort == 0 {
-			return nil, fmt.Errorf("HTTP port must be set")
+	var listenConfig net.ListenConfig
+	if i.HTTPEnabled {
+		if i.HTTPPort == 0 {
+			return fmt.Errorf("HTTP port must be set")
 		}
-		go func() {
-			err = r.Run(":" + strconv.Itoa(instance.HTTPPort))
-			if err != nil {
-				log.Printf("Error running HTTP server: %s", err)
-			}
-		}()
-		log.Printf("HTTP server started on port %d", instance.HTTPPort)
+		listener, err := listenConfig.Listen(ctx, "tcp", ":"+strconv.Itoa(i.HTTPPort))
+		if err != nil {
+			return fmt.Errorf("error starting HTTP server: %v", err)
+		}
+		i.serve(listener, "HTTP server")
+		log.Printf("HTTP server started on port %d", i.HTTPPort)
 	}
-	if instance.UnixSocketEnabled {
-		if _, err = os.Stat(instance.UnixSocketPath); err == nil {
-			return nil, fmt.Errorf("unix socket path %s already exists. Skipping instance creation", instance.UnixSocketPath)
+	if i.UnixSocketEnabled {
+		if _, err = os.Stat(i.UnixSocketPath); err == nil {
+			return fmt.Errorf("unix socket path %s already exists. Skipping instance creation", i.UnixSocketPath)
 		}
-		err = nil
-		go func() {
-			err = r.RunUnix(instance.UnixSocketPath)
-			if err != nil {
-				log.Printf("Error running Unix socket server: %s", err)
-			}
-		}()
-		_, err = os.Stat(instance.UnixSocketPath)
-		for ; err != nil; _, err = os.Stat(instance.UnixSocketPath) {
-			time.Sleep(1 * time.Second)
+		listener, err := listenConfig.Listen(ctx, "unix", i.UnixSocketPath)
+		if err != nil {
+			return fmt.Errorf("error starting Unix socket server: %v", err)
 		}
-		fileModeOctal, err := strconv.ParseUint(instance.UnixSocketPermissions, 8, 32)
+		i.serve(listener, "Unix socket server")
+		fileModeOctal, err := strconv.ParseUint(i.UnixSocketPermissions, 8, 32)
 		if err != nil {
 			log.Printf("error parsing Unix socket permissions: %s", err)
-			return nil, err
+			return err
 		}
-		if err = os.Chmod(instance.UnixSocketPath, os.FileMode(fileModeOctal)); err != nil {
+		if err = os.Chmod(i.UnixSocketPath, os.FileMode(fileModeOctal)); err != nil {
 			log.Printf("Error setting Unix socket permissions: %s", err)
 		}
-		log.Printf("Running on unix socket: %s with file permissions %s", instance.UnixSocketPath, instance.UnixSocketPermissions)
+		log.Printf("Running on unix socket: %s with file permissions %s", i.UnixSocketPath, i.UnixSocketPermissions)
+	}
+	return nil
+}
+
+// Serve the instance's router on a listener in the background, recording any error for Shutdown to return.
+func (i *ProxyInstance) serve(listener net.Listener, name string) {
+	server := &http.Server{Handler: i.router.Handler()}
+	i.serversMu.Lock()
+	i.servers = append(i.servers, server)
+	i.serversMu.Unlock()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error running %s: %s", name, err)
+			i.serversMu.Lock()
+			i.serveErrors = append(i.serveErrors, fmt.Errorf("error running %s: %v", name, err))
+			i.serversMu.Unlock()
+		}
+	}()
+}
+
+// Shutdown stops the instance's listeners, waiting for in-flight requests to finish until ctx is done, then closes
+// the instance. SSE and variable streams are ended rather than waited for. It returns the errors from serving,
+// shutting down and closing.
+func (i *ProxyInstance) Shutdown(ctx context.Context) error {
+	if i.cancel != nil {
+		i.cancel()
+	}
+	i.serversMu.Lock()
+	servers := i.servers
+	i.servers = nil
+	i.serversMu.Unlock()
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := i.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	i.serversMu.Lock()
+	errs = append(errs, i.serveErrors...)
+	i.serversMu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Stop the instance's listeners straight away.
+func (i *ProxyInstance) closeServers() {
+	i.serversMu.Lock()
+	servers := i.servers
+	i.servers = nil
+	i.serversMu.Unlock()
+	for _, server := range servers {
+		_ = server.Close()
 	}
-	return instance, err
 }
 
 // Add the DevCycle client to the request context
@@ -118,6 +336,10 @@ func newRouter(client *devcycle.Client, instance *ProxyInstance) *gin.Engine {
 		r.Use(gin.Logger())
 	}
 	r.Use(gin.Recovery())
+	// Forwarded headers are only believed from the configured proxies, as anyone can set them
+	if err := r.SetTrustedProxies(instance.EventMetadata.TrustedProxies); err != nil {
+		log.Printf("Error setting trusted proxies: %s", err)
+	}
 	r.Use(devCycleMiddleware(client))
 	r.Use(sdkProxyMiddleware(instance))
 	r.GET("/healthz", Health)
@@ -129,19 +351,40 @@ func newRouter(client *devcycle.Client, instance *ProxyInstance) *gin.Engine {
 		v1.POST("/variables", Variable())
 		v1.POST("/features", Feature())
 		v1.POST("/track", Track())
+		v1.POST("/stream/variables", VariableStream())
 		// Events API
 		v1.POST("/events", Track())
 		v1.POST("/events/batch", BatchEvents())
 	}
 	configCDNv1 := r.Group("/config/v1")
+	configCDNv1.Use(ConfigAuthRequired())
 	{
 		configCDNv1.GET("/server/:sdkKey", GetConfig(nil, "v1"))
 	}
 	configCDNv2 := r.Group("/config/v2")
+	configCDNv2.Use(ConfigAuthRequired())
 	{
 		configCDNv2.GET("/server/:sdkKey", GetConfig(client))
 	}
-	r.GET("/event-stream", SSE())
+	r.GET("/event-stream", SSEAuthRequired(), SSE())
+	admin := r.Group("/admin")
+	admin.Use(AdminAuthRequired())
+	{
+		admin.GET("/config/history", ConfigHistory())
+		admin.GET("/config/history/:id", ConfigHistoryVersion())
+		admin.PUT("/config/pin", PinConfig())
+		admin.DELETE("/config/pin", UnpinConfig())
+		admin.GET("/kill-switch", GetKillSwitch())
+		admin.PUT("/kill-switch", SetKillSwitch())
+		admin.DELETE("/kill-switch", ClearKillSwitch())
+		admin.POST("/explain", Explain())
+		admin.POST("/what-if", WhatIf())
+		admin.POST("/simulate", Simulate())
+		admin.POST("/users", ImportUserProfiles())
+		admin.GET("/users/:userId", GetUserProfile())
+		admin.PUT("/users/:userId", PutUserProfile())
+		admin.DELETE("/users/:userId", DeleteUserProfile())
+	}
 
 	return r
 }
@@ -0,0 +1,162 @@
+// Package proxytest provides in-process fakes of the DevCycle config CDN and events API, and helpers to run a proxy
+// instance against them, so the proxy can be tested end to end without an SDK key or network access.
+package proxytest
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/eventsource"
+)
+
+// DefaultConfig is the config served for SDK keys without one of their own. It has a new-checkout feature serving
+// the new-checkout and checkout-copy variables, turned on for users with an @example.com email and off for everyone
+// else.
+//
+//go:embed testdata/config.json
+var DefaultConfig []byte
+
+// ConfigCDN is a fake config CDN. It serves a config per SDK key on /config/v1 and /config/v2, honouring
+// If-None-Match, and publishes a refetchConfig event over SSE whenever a key's config changes.
+type ConfigCDN struct {
+	URL string
+
+	server *httptest.Server
+	sse    *eventsource.Server
+
+	mu       sync.RWMutex
+	configs  map[string]cdnConfig
+	requests map[string]int
+	eventID  int
+}
+
+type cdnConfig struct {
+	body         []byte
+	etag         string
+	lastModified time.Time
+}
+
+// NewConfigCDN starts a fake config CDN serving DefaultConfig for every SDK key. Callers should Close it when done.
+func NewConfigCDN() *ConfigCDN {
+	c := &ConfigCDN{
+		sse:      eventsource.NewServer(),
+		configs:  map[string]cdnConfig{},
+		requests: map[string]int{},
+	}
+	c.sse.ReplayAll = false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config/", c.handleConfig)
+	mux.HandleFunc("/event-stream", func(w http.ResponseWriter, req *http.Request) {
+		c.sse.Handler(req.URL.Query().Get("channel")).ServeHTTP(w, req)
+	})
+	c.server = httptest.NewServer(mux)
+	c.URL = c.server.URL
+	return c
+}
+
+// SetConfig replaces the config served for an SDK key, and tells SDKs connected to the key's SSE stream to refetch it.
+func (c *ConfigCDN) SetConfig(sdkKey string, config []byte) {
+	hash := sha256.Sum256(config)
+	c.mu.Lock()
+	c.configs[sdkKey] = cdnConfig{
+		body:         config,
+		etag:         `"` + hex.EncodeToString(hash[:16]) + `"`,
+		lastModified: time.Now().UTC(),
+	}
+	served := c.configs[sdkKey]
+	c.eventID++
+	id := strconv.Itoa(c.eventID)
+	c.mu.Unlock()
+
+	inner, _ := json.Marshal(map[string]interface{}{
+		"type":         "refetchConfig",
+		"etag":         served.etag,
+		"lastModified": served.lastModified.UnixMilli(),
+	})
+	data, _ := json.Marshal(map[string]string{"data": string(inner)})
+	c.sse.Publish([]string{sdkKey}, sseEvent{id: id, data: string(data)})
+}
+
+// LoadConfig serves the config in a file for an SDK key.
+func (c *ConfigCDN) LoadConfig(sdkKey, path string) error {
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c.SetConfig(sdkKey, config)
+	return nil
+}
+
+// Requests returns how many times the config for an SDK key has been requested, including those answered with a 304.
+func (c *ConfigCDN) Requests(sdkKey string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.requests[sdkKey]
+}
+
+func (c *ConfigCDN) Close() {
+	c.sse.Close()
+	c.server.Close()
+}
+
+func (c *ConfigCDN) config(sdkKey string) cdnConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[sdkKey]++
+	if config, ok := c.configs[sdkKey]; ok {
+		return config
+	}
+	hash := sha256.Sum256(DefaultConfig)
+	return cdnConfig{body: DefaultConfig, etag: `"` + hex.EncodeToString(hash[:16]) + `"`}
+}
+
+func (c *ConfigCDN) handleConfig(w http.ResponseWriter, req *http.Request) {
+	dir, file := path.Split(req.URL.Path)
+	if (dir != "/config/v1/server/" && dir != "/config/v2/server/") || !strings.HasSuffix(file, ".json") {
+		http.NotFound(w, req)
+		return
+	}
+	sdkKey := strings.TrimSuffix(file, ".json")
+	config := c.config(sdkKey)
+	w.Header().Set("ETag", config.etag)
+	if !config.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", config.lastModified.Format(http.TimeFormat))
+	}
+	if req.Header.Get("If-None-Match") == config.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Point SDKs at this server's SSE stream for the key
+	body := config.body
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		fields["sse"] = map[string]string{
+			"hostname": c.URL,
+			"path":     fmt.Sprintf("/event-stream?channel=%s", sdkKey),
+		}
+		body, _ = json.Marshal(fields)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+type sseEvent struct {
+	id   string
+	data string
+}
+
+func (e sseEvent) Id() string    { return e.id }
+func (e sseEvent) Event() string { return "" }
+func (e sseEvent) Data() string  { return e.data }
@@ -0,0 +1,104 @@
+package proxytest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Batch is a request received by the fake events API.
+type Batch struct {
+	Authorization string      `json:"-"`
+	Items         []BatchItem `json:"batch"`
+}
+
+// BatchItem is a user's events within a batch.
+type BatchItem struct {
+	User   map[string]interface{}   `json:"user"`
+	Events []map[string]interface{} `json:"events"`
+}
+
+// EventsAPI is a fake DevCycle events API, recording every batch posted to /v1/events/batch.
+type EventsAPI struct {
+	URL string
+
+	server *httptest.Server
+
+	mu      sync.Mutex
+	batches []Batch
+	status  int
+}
+
+// NewEventsAPI starts a fake events API. Callers should Close it when done.
+func NewEventsAPI() *EventsAPI {
+	e := &EventsAPI{status: http.StatusCreated}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/events/batch", e.handleBatch)
+	e.server = httptest.NewServer(mux)
+	e.URL = e.server.URL
+	return e
+}
+
+// SetStatus sets the status the fake responds to batches with, e.g. to test retries. Batches are recorded whatever
+// the status.
+func (e *EventsAPI) SetStatus(status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = status
+}
+
+// Batches returns the batches received so far, oldest first.
+func (e *EventsAPI) Batches() []Batch {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Batch(nil), e.batches...)
+}
+
+// Events returns every event received so far, across all batches.
+func (e *EventsAPI) Events() []map[string]interface{} {
+	var events []map[string]interface{}
+	for _, batch := range e.Batches() {
+		for _, item := range batch.Items {
+			events = append(events, item.Events...)
+		}
+	}
+	return events
+}
+
+// Reset forgets the batches received so far.
+func (e *EventsAPI) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.batches = nil
+}
+
+func (e *EventsAPI) Close() {
+	e.server.Close()
+}
+
+func (e *EventsAPI) handleBatch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	batch := Batch{}
+	if err = json.Unmarshal(body, &batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	batch.Authorization = req.Header.Get("Authorization")
+
+	e.mu.Lock()
+	e.batches = append(e.batches, batch)
+	status := e.status
+	e.mu.Unlock()
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`{"message":"Successfully received events"}`))
+}
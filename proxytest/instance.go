@@ -0,0 +1,72 @@
+package proxytest
+
+import (
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	sdkproxy "github.com/devcyclehq/sdk-proxy/v2"
+)
+
+// SDKKey is the SDK key test instances are created with.
+const SDKKey = "dvc_server_proxytest"
+
+// Instance is a running proxy instance, served by a test HTTP server on a local port.
+type Instance struct {
+	*sdkproxy.ProxyInstance
+	// The base URL of the instance's HTTP server
+	URL string
+}
+
+// NewInstanceConfig returns the config for a proxy instance using a fake config CDN and events API. It doesn't open
+// any listeners of its own, so it's served through its Handler.
+func NewInstanceConfig(cdn *ConfigCDN, events *EventsAPI) *sdkproxy.ProxyInstance {
+	return &sdkproxy.ProxyInstance{
+		SSEEnabled:  true,
+		SSEHostname: "127.0.0.1",
+		SDKKey:      SDKKey,
+		PlatformData: devcycle.PlatformData{
+			SdkType:         "server",
+			SdkVersion:      devcycle.VERSION,
+			PlatformVersion: runtime.Version(),
+			Platform:        "Go",
+			Hostname:        "proxytest",
+		},
+		SDKConfig: sdkproxy.SDKConfig{
+			EventFlushIntervalMS:    100,
+			ConfigPollingIntervalMS: 1000,
+			RequestTimeout:          5000,
+			ConfigCDNURI:            cdn.URL,
+			EventsAPIURI:            events.URL,
+		},
+	}
+}
+
+// NewInstance starts a proxy instance using a fake config CDN and events API, serving it from a test HTTP server.
+// configure, if given, adjusts the instance's config before it starts. The instance is shut down when the test ends.
+func NewInstance(t testing.TB, cdn *ConfigCDN, events *EventsAPI, configure ...func(*sdkproxy.ProxyInstance)) *Instance {
+	t.Helper()
+	config := NewInstanceConfig(cdn, events)
+	for _, fn := range configure {
+		fn(config)
+	}
+	// The server's port is taken before the instance is set up, so the SSE URL it hands out in configs points at it
+	server := httptest.NewUnstartedServer(nil)
+	config.SSEPort = server.Listener.Addr().(*net.TCPAddr).Port
+	config.Default()
+	handler, err := config.Handler()
+	if err != nil {
+		server.Close()
+		t.Fatalf("Error creating proxy instance: %s", err)
+	}
+	server.Config.Handler = handler
+	server.Start()
+	t.Cleanup(func() {
+		// Streams are ended by closing the instance, so the server doesn't wait on them
+		_ = config.Close()
+		server.Close()
+	})
+	return &Instance{ProxyInstance: config, URL: server.URL}
+}
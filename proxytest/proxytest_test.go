@@ -0,0 +1,299 @@
+package proxytest_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sdkproxy "github.com/devcyclehq/sdk-proxy/v2"
+	"github.com/devcyclehq/sdk-proxy/v2/proxytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getConfig(t *testing.T, url, etag string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestConfigCDN(t *testing.T) {
+	cdn := proxytest.NewConfigCDN()
+	defer cdn.Close()
+	url := cdn.URL + "/config/v2/server/dvc_server_key.json"
+
+	resp, body := getConfig(t, url, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"new-checkout"`)
+	assert.Contains(t, body, `"path":"/event-stream?channel=dvc_server_key"`)
+	etag := resp.Header.Get("ETag")
+	resp, _ = getConfig(t, url, etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, 2, cdn.Requests("dvc_server_key"))
+
+	stream, err := http.Get(cdn.URL + "/event-stream?channel=dvc_server_key")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	// Give the stream time to subscribe before publishing
+	time.Sleep(50 * time.Millisecond)
+
+	cdn.SetConfig("dvc_server_key", []byte(`{"features": [], "variables": []}`))
+	resp, body = getConfig(t, url, etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	assert.NotContains(t, body, `"new-checkout"`)
+
+	reader := bufio.NewReader(stream.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if strings.HasPrefix(line, "data:") {
+			assert.Contains(t, line, "refetchConfig")
+			assert.Contains(t, line, strings.Trim(resp.Header.Get("ETag"), `"`))
+			break
+		}
+	}
+
+	resp, _ = getConfig(t, cdn.URL+"/config/v2/server/other.txt", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestInstance(t *testing.T) {
+	cdn := proxytest.NewConfigCDN()
+	defer cdn.Close()
+	events := proxytest.NewEventsAPI()
+	defer events.Close()
+	instance := proxytest.NewInstance(t, cdn, events)
+
+	// DefaultConfig turns new-checkout on for @example.com users, until the config is changed to turn it off
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == true }, 5*time.Second, 50*time.Millisecond)
+	cdn.SetConfig(proxytest.SDKKey, bytes.ReplaceAll(proxytest.DefaultConfig,
+		[]byte(`"_variation": "variation-on"`), []byte(`"_variation": "variation-off"`)))
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == false }, 5*time.Second, 50*time.Millisecond)
+
+	resp, err := http.Post(instance.URL+"/v1/events/batch", "application/json", strings.NewReader(
+		`{"batch": [{"user": {"user_id": "u1"}, "events": [{"type": "customEvent", "customType": "purchase"}]}]}`,
+	))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err := http.NewRequest("POST", instance.URL+"/v1/events/batch", strings.NewReader(
+		`{"batch": [{"user": {"user_id": "u1"}, "events": [{"type": "customEvent", "customType": "purchase"}]}]}`,
+	))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", proxytest.SDKKey)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	batches := events.Batches()
+	require.Len(t, batches, 1)
+	assert.Equal(t, proxytest.SDKKey, batches[0].Authorization)
+	assert.Equal(t, "u1", batches[0].Items[0].User["user_id"])
+	require.Len(t, events.Events(), 1)
+	assert.Equal(t, "purchase", events.Events()[0]["customType"])
+
+	events.SetStatus(http.StatusServiceUnavailable)
+	events.Reset()
+	resp, err = http.DefaultClient.Do(mustRequest(t, instance.URL+"/v1/events/batch", `{"batch": []}`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(t, events.Batches(), 1)
+}
+
+func mustRequest(t *testing.T, url, body string) *http.Request {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", proxytest.SDKKey)
+	return req
+}
+
+// Evaluates the new-checkout variable for a user targeted by DefaultConfig.
+func newCheckout(t *testing.T, instance *proxytest.Instance) interface{} {
+	req, err := http.NewRequest("POST", instance.URL+"/v1/variables", strings.NewReader(`{"user_id": "u1", "email": "u1@example.com"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", proxytest.SDKKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var variables map[string]struct {
+		Value interface{} `json:"value"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&variables); err != nil {
+		return nil
+	}
+	return variables["new-checkout"].Value
+}
+
+func adminRequest(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestConfigPinning(t *testing.T) {
+	cdn := proxytest.NewConfigCDN()
+	defer cdn.Close()
+	events := proxytest.NewEventsAPI()
+	defer events.Close()
+	cdn.SetConfig(proxytest.SDKKey, proxytest.DefaultConfig)
+	instance := proxytest.NewInstance(t, cdn, events, func(i *sdkproxy.ProxyInstance) {
+		i.ConfigHistory = sdkproxy.ConfigHistoryConfig{Enabled: true}
+		i.AdminToken = "admin-token"
+	})
+
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == true }, 5*time.Second, 50*time.Millisecond)
+	cdn.SetConfig(proxytest.SDKKey, bytes.ReplaceAll(proxytest.DefaultConfig,
+		[]byte(`"_variation": "variation-on"`), []byte(`"_variation": "variation-off"`)))
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == false }, 5*time.Second, 50*time.Millisecond)
+
+	// Pinning the original config, which is older than the one the client has, takes the client back to it
+	resp := adminRequest(t, "GET", instance.URL+"/admin/config/history", "")
+	var history struct {
+		Versions []sdkproxy.ConfigVersion `json:"versions"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	_ = resp.Body.Close()
+	require.Len(t, history.Versions, 2)
+	original := history.Versions[len(history.Versions)-1]
+	resp = adminRequest(t, "PUT", instance.URL+"/admin/config/pin", `{"id": `+strconv.Itoa(original.ID)+`}`)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == true }, 5*time.Second, 50*time.Millisecond)
+
+	resp = adminRequest(t, "DELETE", instance.URL+"/admin/config/pin", "")
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == false }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestVariableStreamKillSwitch(t *testing.T) {
+	cdn := proxytest.NewConfigCDN()
+	defer cdn.Close()
+	events := proxytest.NewEventsAPI()
+	defer events.Close()
+	instance := proxytest.NewInstance(t, cdn, events, func(i *sdkproxy.ProxyInstance) {
+		i.AdminToken = "admin-token"
+	})
+	assert.Eventually(t, func() bool { return newCheckout(t, instance) == true }, 5*time.Second, 50*time.Millisecond)
+
+	req, err := http.NewRequest("POST", instance.URL+"/v1/stream/variables", strings.NewReader(`{"user_id": "u1", "email": "u1@example.com"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", proxytest.SDKKey)
+	req.Header.Set("Content-Type", "application/json")
+	stream, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	reader := bufio.NewReader(stream.Body)
+	nextValue := func() interface{} {
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var variables map[string]struct {
+					Value interface{} `json:"value"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(data), &variables))
+				return variables["new-checkout"].Value
+			}
+		}
+	}
+	assert.Equal(t, true, nextValue())
+
+	// The stream is re-evaluated as soon as the kill switch changes
+	resp := adminRequest(t, "PUT", instance.URL+"/admin/kill-switch", `{"values": {"new-checkout": false}}`)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, false, nextValue())
+	resp = adminRequest(t, "DELETE", instance.URL+"/admin/kill-switch", "")
+	_ = resp.Body.Close()
+	assert.Equal(t, true, nextValue())
+}
+
+func TestEmulator(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "flags.yaml")
+	writeFlags := func(everyone, alice bool) {
+		flags := fmt.Sprintf("variables:\n  new-checkout:\n    value: %t\n    targets:\n      - users: [alice]\n        value: %t\n", everyone, alice)
+		require.NoError(t, os.WriteFile(flagFile, []byte(flags), 0o600))
+	}
+	writeFlags(false, true)
+
+	// The fakes are never reached, as the emulator stands in for both
+	cdn := proxytest.NewConfigCDN()
+	defer cdn.Close()
+	events := proxytest.NewEventsAPI()
+	defer events.Close()
+	instance := proxytest.NewInstance(t, cdn, events, func(i *sdkproxy.ProxyInstance) {
+		i.Emulator.FlagFile = flagFile
+	})
+	variable := func(userID string) interface{} {
+		req, err := http.NewRequest("POST", instance.URL+"/v1/variables/new-checkout", strings.NewReader(`{"user_id": "`+userID+`"}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", proxytest.SDKKey)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var result struct {
+			Value interface{} `json:"value"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil
+		}
+		return result.Value
+	}
+	assert.Eventually(t, func() bool { return variable("alice") == true }, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, false, variable("carol"))
+
+	stream, err := http.Get(instance.URL + "/event-stream?sdkKey=" + proxytest.SDKKey)
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	pushed := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data:") {
+				pushed <- scanner.Text()
+				return
+			}
+		}
+	}()
+
+	// Editing the flag file changes the values served, and tells SSE subscribers to refetch their config
+	writeFlags(true, false)
+	assert.Eventually(t, func() bool { return variable("carol") == true }, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, false, variable("alice"))
+	select {
+	case data := <-pushed:
+		assert.Contains(t, data, "refetchConfig")
+	case <-time.After(5 * time.Second):
+		t.Error("No config update was published to SSE subscribers")
+	}
+}
@@ -0,0 +1,158 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/devcyclehq/go-server-sdk/v2/api"
+	"github.com/launchdarkly/eventsource"
+)
+
+// Named SSE events published to subscribers when SSEClientEvents is enabled. SDKs only act on unnamed events,
+// so these don't interfere with config updates.
+const (
+	SSEEventUpstreamConnected    = "upstreamConnected"
+	SSEEventUpstreamDisconnected = "upstreamDisconnected"
+	SSEEventUpstreamError        = "upstreamError"
+	SSEEventConfigUpdated        = "configUpdated"
+)
+
+// How long to wait before restarting the rebroadcaster after it panics.
+const rebroadcasterRestartDelay = time.Second
+
+// rebroadcasterStats counts the client events handled by the rebroadcaster, for reporting on /healthz.
+type rebroadcasterStats struct {
+	upstreamConnected  int32
+	upstreamConnects   int64
+	upstreamFailures   int64
+	errors             int64
+	configUpdates      int64
+	rebroadcasts       int64
+	unexpectedPayloads int64
+	restarts           int64
+	lastEventAt        int64
+}
+
+func (s *rebroadcasterStats) toMap() map[string]interface{} {
+	stats := map[string]interface{}{
+		"upstreamConnected":  atomic.LoadInt32(&s.upstreamConnected) == 1,
+		"upstreamConnects":   atomic.LoadInt64(&s.upstreamConnects),
+		"upstreamFailures":   atomic.LoadInt64(&s.upstreamFailures),
+		"errors":             atomic.LoadInt64(&s.errors),
+		"configUpdates":      atomic.LoadInt64(&s.configUpdates),
+		"rebroadcasts":       atomic.LoadInt64(&s.rebroadcasts),
+		"unexpectedPayloads": atomic.LoadInt64(&s.unexpectedPayloads),
+		"restarts":           atomic.LoadInt64(&s.restarts),
+	}
+	if lastEventAt := atomic.LoadInt64(&s.lastEventAt); lastEventAt > 0 {
+		stats["lastEventAt"] = time.UnixMilli(lastEventAt).UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+// EventRebroadcaster relays the SDK's client events to this instance's SSE subscribers until the event channel is
+// closed or the instance is closed. If handling an event panics, it logs the panic and starts again.
+func (i *ProxyInstance) EventRebroadcaster() {
+	for !i.rebroadcastEvents() {
+		atomic.AddInt64(&i.rebroadcasterStats.restarts, 1)
+		select {
+		case <-time.After(rebroadcasterRestartDelay):
+		case <-i.ctx.Done():
+			return
+		}
+		log.Printf("Restarting SSE rebroadcaster")
+	}
+}
+
+// Handle client events until the channel is closed or the instance is closed, returning false if it stopped because
+// of a panic.
+func (i *ProxyInstance) rebroadcastEvents() (done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("SSE rebroadcaster panicked: %v", r)
+			done = false
+		}
+	}()
+	for {
+		select {
+		case event, ok := <-i.sseEvents:
+			if !ok {
+				return true
+			}
+			i.handleClientEvent(event)
+		case <-i.ctx.Done():
+			return true
+		}
+	}
+}
+
+func (i *ProxyInstance) handleClientEvent(event api.ClientEvent) {
+	stats := &i.rebroadcasterStats
+	atomic.StoreInt64(&stats.lastEventAt, time.Now().UnixMilli())
+
+	switch event.EventType {
+	case api.ClientEventType_RealtimeUpdates:
+		upstream, ok := event.EventData.(eventsource.Event)
+		if !ok {
+			atomic.AddInt64(&stats.unexpectedPayloads, 1)
+			log.Printf("Ignoring SSE event with unexpected payload of type %T", event.EventData)
+			return
+		}
+		atomic.AddInt64(&stats.rebroadcasts, 1)
+		i.sseAnnouncements.recordUpstream(upstream.Data())
+		i.publishSSE(upstream.Event(), upstream.Data())
+		log.Printf("Rebroadcasting SSE event: %s\n", upstream.Data())
+	case api.ClientEventType_InternalSSEConnected:
+		atomic.StoreInt32(&stats.upstreamConnected, 1)
+		atomic.AddInt64(&stats.upstreamConnects, 1)
+		log.Printf("Connected to DevCycle SSE for rebroadcasting.\n")
+		i.publishClientEvent(SSEEventUpstreamConnected, event)
+	case api.ClientEventType_InternalSSEFailure:
+		atomic.StoreInt32(&stats.upstreamConnected, 0)
+		atomic.AddInt64(&stats.upstreamFailures, 1)
+		log.Printf("Disconnected from DevCycle SSE: %s", clientEventDetail(event))
+		i.publishClientEvent(SSEEventUpstreamDisconnected, event)
+	case api.ClientEventType_Error:
+		atomic.AddInt64(&stats.errors, 1)
+		log.Printf("DevCycle SDK error: %s", clientEventDetail(event))
+		i.publishClientEvent(SSEEventUpstreamError, event)
+	case api.ClientEventType_ConfigUpdated:
+		atomic.AddInt64(&stats.configUpdates, 1)
+		log.Printf("DevCycle config updated")
+		i.publishClientEvent(SSEEventConfigUpdated, event)
+	case api.ClientEventType_Initialized, api.ClientEventType_InternalNewConfigAvailable:
+	default:
+		log.Printf("Ignoring unknown DevCycle client event type: %s", event.EventType)
+	}
+}
+
+// Publish a named SSE event describing a client event, if enabled for this instance. These aren't recorded for
+// replay, since they describe the proxy's state at the time rather than changes clients need to catch up on.
+func (i *ProxyInstance) publishClientEvent(name string, event api.ClientEvent) {
+	if !i.SSEClientEvents {
+		return
+	}
+	data := map[string]interface{}{"status": event.Status}
+	if event.Error != nil {
+		data["error"] = event.Error.Error()
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling SSE client event: %s", err)
+		return
+	}
+	i.sseServer.Publish([]string{i.SDKKey}, sseEvent{event: name, data: string(body)})
+}
+
+func clientEventDetail(event api.ClientEvent) string {
+	if event.Error != nil {
+		return event.Error.Error()
+	}
+	if event.Status != "" {
+		return event.Status
+	}
+	return fmt.Sprintf("%v", event.EventData)
+}
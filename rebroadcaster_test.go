@@ -0,0 +1,72 @@
+package sdk_proxy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devcyclehq/go-server-sdk/v2/api"
+	"github.com/launchdarkly/eventsource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRebroadcastingInstance(t *testing.T) *ProxyInstance {
+	instance := &ProxyInstance{
+		SDKKey:        "dvc_server_key",
+		sseEvents:     make(chan api.ClientEvent, 10),
+		sseServer:     eventsource.NewServer(),
+		sseRepository: newSSERepository(10, time.Minute),
+	}
+	instance.ctx, instance.cancel = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		instance.cancel()
+		instance.sseServer.Close()
+	})
+	return instance
+}
+
+func TestHandleClientEvents(t *testing.T) {
+	instance := newRebroadcastingInstance(t)
+	stats := &instance.rebroadcasterStats
+
+	instance.handleClientEvent(api.ClientEvent{EventType: api.ClientEventType_InternalSSEConnected})
+	instance.handleClientEvent(api.ClientEvent{EventType: api.ClientEventType_RealtimeUpdates, EventData: "not an event"})
+	instance.handleClientEvent(api.ClientEvent{
+		EventType: api.ClientEventType_RealtimeUpdates,
+		EventData: sseEvent{data: `{"type":"refetchConfig"}`},
+	})
+	instance.handleClientEvent(api.ClientEvent{EventType: api.ClientEventType_Error, Error: errors.New("boom")})
+	instance.handleClientEvent(api.ClientEvent{EventType: api.ClientEventType_InternalSSEFailure})
+	instance.handleClientEvent(api.ClientEvent{EventType: "somethingNew"})
+
+	assert.Equal(t, int64(1), stats.upstreamConnects)
+	assert.Equal(t, int64(1), stats.upstreamFailures)
+	assert.Equal(t, int32(0), stats.upstreamConnected)
+	assert.Equal(t, int64(1), stats.errors)
+	assert.Equal(t, int64(1), stats.unexpectedPayloads)
+	assert.Equal(t, int64(1), stats.rebroadcasts)
+	assert.Equal(t, []string{`{"type":"refetchConfig"}`}, replayed(instance.sseRepository.Replay("dvc_server_key", "")))
+}
+
+func TestEventRebroadcasterRestarts(t *testing.T) {
+	instance := newRebroadcastingInstance(t)
+	// Publishing without a repository panics, which should restart the rebroadcaster rather than stop it
+	repository := instance.sseRepository
+	instance.sseRepository = nil
+	go instance.EventRebroadcaster()
+
+	instance.sseEvents <- api.ClientEvent{EventType: api.ClientEventType_RealtimeUpdates, EventData: sseEvent{data: "1"}}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&instance.rebroadcasterStats.restarts) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	instance.sseRepository = repository
+	instance.sseEvents <- api.ClientEvent{EventType: api.ClientEventType_RealtimeUpdates, EventData: sseEvent{data: "2"}}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&instance.rebroadcasterStats.rebroadcasts) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"2"}, replayed(repository.Replay("dvc_server_key", "")))
+}
@@ -0,0 +1,286 @@
+package sdk_proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+)
+
+// UserSource calls each for every user in a sample, stopping at the first error.
+type UserSource func(each func(user devcycle.User) error) error
+
+// NDJSONUsers reads a sample of users from newline-delimited JSON, one user object per line. Blank lines are skipped.
+func NDJSONUsers(r io.Reader) UserSource {
+	return func(each func(user devcycle.User) error) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var user devcycle.User
+			if err := json.Unmarshal([]byte(text), &user); err != nil {
+				return fmt.Errorf("invalid user on line %d: %v", line, err)
+			}
+			if err := each(user); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+}
+
+// UserGenerator describes a sample of users to generate rather than read from a file. Each user gets a unique user ID,
+// and a value for each attribute picked at random from the values given for it.
+type UserGenerator struct {
+	Count        int    `json:"count"`
+	UserIDPrefix string `json:"userIdPrefix,omitempty"`
+	// Keyed by user field (email, name, language, country, appVersion, appBuild, deviceModel), or customData.<key>
+	// and privateCustomData.<key> for custom data.
+	Attributes map[string][]interface{} `json:"attributes,omitempty"`
+	// Seeds the random choice of attribute values, so the same spec always generates the same users.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+func (g UserGenerator) validate() error {
+	if g.Count <= 0 {
+		return fmt.Errorf("the generator count must be positive")
+	}
+	for attribute, values := range g.Attributes {
+		if len(values) == 0 {
+			return fmt.Errorf("no values given for generated attribute %q", attribute)
+		}
+		if _, err := setUserAttribute(devcycle.User{}, attribute, values[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Users returns the generated users.
+func (g UserGenerator) Users() UserSource {
+	return func(each func(user devcycle.User) error) error {
+		if err := g.validate(); err != nil {
+			return err
+		}
+		prefix := g.UserIDPrefix
+		if prefix == "" {
+			prefix = "user-"
+		}
+		// Attributes are picked in a fixed order so the seed generates the same users every time
+		attributes := make([]string, 0, len(g.Attributes))
+		for attribute := range g.Attributes {
+			attributes = append(attributes, attribute)
+		}
+		sort.Strings(attributes)
+		random := rand.New(rand.NewSource(g.Seed))
+		for n := 0; n < g.Count; n++ {
+			user := devcycle.User{UserId: prefix + strconv.Itoa(n)}
+			for _, attribute := range attributes {
+				values := g.Attributes[attribute]
+				user, _ = setUserAttribute(user, attribute, values[random.Intn(len(values))])
+			}
+			if err := each(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func setUserAttribute(user devcycle.User, attribute string, value interface{}) (devcycle.User, error) {
+	if key, ok := strings.CutPrefix(attribute, "customData."); ok {
+		customData := make(map[string]interface{}, len(user.CustomData)+1)
+		for k, v := range user.CustomData {
+			customData[k] = v
+		}
+		customData[key] = value
+		user.CustomData = customData
+		return user, nil
+	}
+	if key, ok := strings.CutPrefix(attribute, "privateCustomData."); ok {
+		customData := make(map[string]interface{}, len(user.PrivateCustomData)+1)
+		for k, v := range user.PrivateCustomData {
+			customData[k] = v
+		}
+		customData[key] = value
+		user.PrivateCustomData = customData
+		return user, nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return user, fmt.Errorf("values of generated attribute %q must be strings", attribute)
+	}
+	switch attribute {
+	case "email":
+		user.Email = text
+	case "name":
+		user.Name = text
+	case "language":
+		user.Language = text
+	case "country":
+		user.Country = text
+	case "appVersion":
+		user.AppVersion = text
+	case "appBuild":
+		user.AppBuild = text
+	case "deviceModel":
+		user.DeviceModel = text
+	default:
+		return user, fmt.Errorf("unknown generated attribute %q", attribute)
+	}
+	return user, nil
+}
+
+// DistributionShare is how many of the simulated users fell into part of a distribution.
+type DistributionShare struct {
+	Count    int     `json:"count"`
+	Fraction float64 `json:"fraction"`
+}
+
+// VariationDistribution is the share of simulated users served one of a feature's variations.
+type VariationDistribution struct {
+	EvaluatedVariation
+	DistributionShare
+}
+
+// TargetDistribution is how the simulated users fared against one of a feature's targeting rules. Users only reach a
+// rule if no earlier rule served them a variation.
+type TargetDistribution struct {
+	ID              string `json:"_id"`
+	Name            string `json:"name,omitempty"`
+	Index           int    `json:"index"`
+	AudienceMatched int    `json:"audienceMatched"`
+	NotInRollout    int    `json:"notInRollout"`
+	DistributionShare
+}
+
+// Distribution is the result of evaluating a feature for a sample of users.
+type Distribution struct {
+	Feature     string                  `json:"feature"`
+	Users       int                     `json:"users"`
+	Variations  []VariationDistribution `json:"variations"`
+	Targets     []TargetDistribution    `json:"targets"`
+	NotTargeted DistributionShare       `json:"notTargeted"`
+	// How many users the evaluator, which the per-rule counts come from, chose a different variation for than the
+	// DevCycle client
+	EvaluatorMismatches int `json:"evaluatorMismatches,omitempty"`
+}
+
+// The key simulation clients are created with, which is never sent anywhere as they only use a loopback config CDN.
+const simulationSDKKey = "dvc_server_simulation"
+
+// SimulateDistribution evaluates a feature in a /config/v2 config for every user in a sample, returning the share of
+// users served each variation and the hits on each targeting rule. The variations are those served by a DevCycle
+// client using the config, while the hits on each rule come from the evaluator, with rollouts evaluated as of now.
+func SimulateDistribution(rawConfig []byte, featureKey string, platformData devcycle.PlatformData, users UserSource, now time.Time) (*Distribution, error) {
+	config, err := parseBucketingConfig(rawConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	if config.feature(featureKey) == nil {
+		return nil, fmt.Errorf("feature %s not found in config", featureKey)
+	}
+	client, err := newStaticConfigClient(simulationSDKKey, rawConfig, platformData, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return config.simulateDistribution(featureKey, platformData, client.AllFeatures, users, now)
+}
+
+// simulateDistribution counts the variations served by allFeatures, a DevCycle client's evaluation of the config,
+// and the audience and rollout hits on each targeting rule from the evaluator.
+func (c *bucketingConfig) simulateDistribution(featureKey string, platformData devcycle.PlatformData,
+	allFeatures func(user devcycle.User) (map[string]devcycle.Feature, error), users UserSource, now time.Time) (*Distribution, error) {
+	feature := c.feature(featureKey)
+	if feature == nil {
+		return nil, fmt.Errorf("feature %s not found in config", featureKey)
+	}
+	distribution := &Distribution{
+		Feature:    feature.Key,
+		Variations: make([]VariationDistribution, 0, len(feature.Variations)),
+		Targets:    make([]TargetDistribution, 0, len(feature.Configuration.Targets)),
+	}
+	variations := map[string]int{}
+	for v, variation := range feature.Variations {
+		variations[variation.ID] = v
+		distribution.Variations = append(distribution.Variations, VariationDistribution{
+			EvaluatedVariation: EvaluatedVariation{ID: variation.ID, Key: variation.Key, Name: variation.Name},
+		})
+	}
+	for t, target := range feature.Configuration.Targets {
+		distribution.Targets = append(distribution.Targets, TargetDistribution{ID: target.ID, Name: target.Name, Index: t})
+	}
+
+	err := users(func(user devcycle.User) error {
+		features, err := allFeatures(user)
+		if err != nil {
+			return fmt.Errorf("error evaluating user %s: %w", user.UserId, err)
+		}
+		evaluation := c.evaluateFeature(feature, bucketingUserAttributes(user, platformData), now)
+		distribution.Users++
+		for _, target := range evaluation.Targets {
+			if target.AudiencePassed {
+				distribution.Targets[target.Index].AudienceMatched++
+			}
+			if target.RolloutPassed != nil && !*target.RolloutPassed {
+				distribution.Targets[target.Index].NotInRollout++
+			}
+		}
+		if evaluation.Target != nil && evaluation.Variation != nil {
+			distribution.Targets[evaluation.Target.Index].Count++
+		}
+
+		served, ok := features[feature.Key]
+		evaluated := ""
+		if evaluation.Variation != nil {
+			evaluated = evaluation.Variation.ID
+		}
+		if evaluated != served.Variation {
+			distribution.EvaluatorMismatches++
+		}
+		if !ok {
+			distribution.NotTargeted.Count++
+			return nil
+		}
+		if v, ok := variations[served.Variation]; ok {
+			distribution.Variations[v].Count++
+		} else {
+			// Not a variation in the config's copy of the feature, which shouldn't happen
+			variations[served.Variation] = len(distribution.Variations)
+			distribution.Variations = append(distribution.Variations, VariationDistribution{
+				EvaluatedVariation: EvaluatedVariation{ID: served.Variation, Key: served.VariationKey, Name: served.VariationName},
+				DistributionShare:  DistributionShare{Count: 1},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	distribution.NotTargeted.setFraction(distribution.Users)
+	for v := range distribution.Variations {
+		distribution.Variations[v].setFraction(distribution.Users)
+	}
+	for t := range distribution.Targets {
+		distribution.Targets[t].setFraction(distribution.Users)
+	}
+	return distribution, nil
+}
+
+func (s *DistributionShare) setFraction(users int) {
+	if users > 0 {
+		s.Fraction = float64(s.Count) / float64(users)
+	}
+}
@@ -0,0 +1,105 @@
+package sdk_proxy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulateDistribution(t *testing.T) {
+	generator := UserGenerator{
+		Count: 10000,
+		Attributes: map[string][]interface{}{
+			"email":           {"dev@example.com", "customer@other.com"},
+			"appVersion":      {"2.10.0"},
+			"customData.plan": {"pro", "free"},
+		},
+	}
+	platform := devcycle.PlatformData{Platform: "Go"}
+	rawConfig := sdkTestConfig(t)
+	distribution, err := SimulateDistribution(rawConfig, "checkout", platform, generator.Users(), time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, 10000, distribution.Users)
+	require.Len(t, distribution.Variations, 2)
+	require.Len(t, distribution.Targets, 3)
+	assert.Equal(t, "control", distribution.Variations[0].Key)
+	assert.InDelta(t, 1250, distribution.Variations[0].Count, 150)
+	assert.InDelta(t, 6250, distribution.Variations[1].Count, 150)
+	assert.InDelta(t, 5000, distribution.Targets[0].Count, 150)
+	assert.InDelta(t, 0.5, distribution.Targets[0].Fraction, 0.015)
+	assert.InDelta(t, 2500, distribution.Targets[1].AudienceMatched, 150)
+	assert.Equal(t, distribution.Targets[1].AudienceMatched, distribution.Targets[1].Count)
+	assert.Equal(t, distribution.NotTargeted.Count, distribution.Targets[2].NotInRollout)
+	assert.Equal(t, 0, distribution.Targets[2].Count)
+	assert.Equal(t, 10000, distribution.Variations[0].Count+distribution.Variations[1].Count+distribution.NotTargeted.Count)
+	assert.Zero(t, distribution.EvaluatorMismatches)
+
+	again, err := SimulateDistribution(rawConfig, "checkout", platform, generator.Users(), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, distribution, again)
+
+	_, err = SimulateDistribution(rawConfig, "missing", platform, generator.Users(), time.Now())
+	assert.Error(t, err)
+	generator.Attributes["favouriteColour"] = []interface{}{"blue"}
+	_, err = SimulateDistribution(rawConfig, "checkout", platform, generator.Users(), time.Now())
+	assert.Error(t, err)
+}
+
+func TestNDJSONUsers(t *testing.T) {
+	sample := `{"user_id": "u1", "email": "dev@example.com"}
+
+{"user_id": "u2", "customData": {"plan": "pro"}, "appVersion": "3.0"}
+`
+	var users []devcycle.User
+	err := NDJSONUsers(strings.NewReader(sample))(func(user devcycle.User) error {
+		users = append(users, user)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "dev@example.com", users[0].Email)
+	assert.Equal(t, "pro", users[1].CustomData["plan"])
+
+	err = NDJSONUsers(strings.NewReader("{\"user_id\": \"u1\"}\nnot json\n"))(func(devcycle.User) error { return nil })
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestSimulateDistributionCounts(t *testing.T) {
+	config, err := parseBucketingConfig([]byte(evaluatorTestConfig))
+	require.NoError(t, err)
+	sample := `{"user_id": "u1", "email": "dev@example.com"}
+{"user_id": "u2", "email": "someone@other.com"}
+{"user_id": "u3", "email": "dev@example.com"}
+`
+	// The variations come from the client, even where the evaluator disagrees
+	served := map[string]devcycle.Feature{
+		"u1": {Key: "checkout", Variation: "var-b"},
+		"u3": {Key: "checkout", Variation: "var-a"},
+	}
+	allFeatures := func(user devcycle.User) (map[string]devcycle.Feature, error) {
+		features := map[string]devcycle.Feature{}
+		if feature, ok := served[user.UserId]; ok {
+			features["checkout"] = feature
+		}
+		return features, nil
+	}
+	distribution, err := config.simulateDistribution("checkout", devcycle.PlatformData{}, allFeatures, NDJSONUsers(strings.NewReader(sample)), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 3, distribution.Users)
+	assert.Equal(t, 1, distribution.Variations[0].Count)
+	assert.Equal(t, 1, distribution.Variations[1].Count)
+	assert.Equal(t, 1, distribution.NotTargeted.Count)
+	assert.Equal(t, 2, distribution.Targets[0].Count)
+	assert.Equal(t, 2, distribution.Targets[0].AudienceMatched)
+	assert.Equal(t, 1, distribution.EvaluatorMismatches)
+
+	failing := func(devcycle.User) (map[string]devcycle.Feature, error) { return nil, errors.New("not initialized") }
+	_, err = config.simulateDistribution("checkout", devcycle.PlatformData{}, failing, NDJSONUsers(strings.NewReader(sample)), time.Now())
+	assert.ErrorContains(t, err, "not initialized")
+}
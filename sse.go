@@ -0,0 +1,135 @@
+package sdk_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Send a comment on the instance's SSE channel at a fixed interval, so that load balancers and proxies in front of
+// the proxy don't close connections that are otherwise idle between config updates.
+func (i *ProxyInstance) sseHeartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			i.sseServer.PublishComment([]string{i.SDKKey}, "keepalive")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sseResponseWriter writes a retry: field as soon as the SSE response headers are sent, telling clients how long to
+// wait before reconnecting.
+type sseResponseWriter struct {
+	gin.ResponseWriter
+	retryMS     int64
+	wroteHeader bool
+}
+
+func (w *sseResponseWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	if !w.wroteHeader && code == 200 {
+		w.wroteHeader = true
+		_, _ = fmt.Fprintf(w.ResponseWriter, "retry: %d\n\n", w.retryMS)
+	}
+}
+
+// How many upstream config ETags are remembered for de-duplicating the proxy's own config update events.
+const maxAnnouncedETags = 16
+
+// sseAnnouncements tracks the config updates already announced on an instance's SSE channel by the upstream stream,
+// so the polling fallback only publishes updates that subscribers haven't been told about.
+type sseAnnouncements struct {
+	mu    sync.Mutex
+	etags []string
+	// Whether an upstream update without an ETag has been rebroadcast since the last config change was seen
+	unidentified bool
+}
+
+func (a *sseAnnouncements) recordUpstream(data string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	etag := refetchConfigETag(data)
+	if etag == "" {
+		a.unidentified = true
+		return
+	}
+	a.etags = append(a.etags, etag)
+	if len(a.etags) > maxAnnouncedETags {
+		a.etags = a.etags[len(a.etags)-maxAnnouncedETags:]
+	}
+}
+
+// Whether a config change still needs announcing. Either way, the change is counted as seen.
+func (a *sseAnnouncements) shouldAnnounce(etag string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.unidentified {
+		a.unidentified = false
+		return false
+	}
+	for _, announced := range a.etags {
+		if announced == etag {
+			return false
+		}
+	}
+	return true
+}
+
+// Publish a config update event on the instance's SSE channel for a config change that didn't arrive over the
+// upstream stream, so subscribers keep refetching their config when the upstream stream is down.
+func (i *ProxyInstance) publishConfigChange(_, current watchedConfig) {
+	if !i.sseAnnouncements.shouldAnnounce(current.ETag) {
+		return
+	}
+	data, err := refetchConfigEvent(current)
+	if err != nil {
+		log.Printf("Error creating config update SSE event: %s", err)
+		return
+	}
+	i.publishSSE("", data)
+	log.Printf("Publishing config update from polling: %s", current.ETag)
+}
+
+// Return the ETag of a refetchConfig message, in the format the upstream stream sends it, or "" if there isn't one.
+func refetchConfigETag(data string) string {
+	var message struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(data), &message); err != nil {
+		return ""
+	}
+	var payload struct {
+		Type string `json:"type"`
+		ETag string `json:"etag"`
+	}
+	if err := json.Unmarshal([]byte(message.Data), &payload); err != nil || payload.Type != "refetchConfig" {
+		return ""
+	}
+	return payload.ETag
+}
+
+func refetchConfigEvent(config watchedConfig) (string, error) {
+	payload := map[string]interface{}{"type": "refetchConfig", "etag": config.ETag}
+	if lastModified, err := http.ParseTime(config.LastModified); err == nil {
+		payload["lastModified"] = lastModified.UnixMilli()
+	}
+	inner, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	message, err := json.Marshal(map[string]string{"data": string(inner)})
+	if err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
@@ -0,0 +1,90 @@
+package sdk_proxy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/eventsource"
+)
+
+// sseEvent is an event published on the proxy's SSE server, with an ID assigned by the proxy.
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+func (e sseEvent) Id() string    { return e.id }
+func (e sseEvent) Event() string { return e.event }
+func (e sseEvent) Data() string  { return e.data }
+
+type storedSSEEvent struct {
+	seq   uint64
+	event eventsource.Event
+	added time.Time
+}
+
+// sseRepository keeps a bounded, time-limited history of the events published on each channel, so that clients
+// reconnecting with a Last-Event-ID are replayed whatever they missed. Event IDs are sequence numbers seeded from the
+// clock at startup, so they keep increasing across restarts of the proxy.
+type sseRepository struct {
+	maxEvents int
+	maxAge    time.Duration
+
+	mu      sync.RWMutex
+	seq     uint64
+	history map[string][]storedSSEEvent
+}
+
+func newSSERepository(maxEvents int, maxAge time.Duration) *sseRepository {
+	return &sseRepository{
+		maxEvents: maxEvents,
+		maxAge:    maxAge,
+		seq:       uint64(time.Now().UnixNano()),
+		history:   make(map[string][]storedSSEEvent),
+	}
+}
+
+// Add assigns the next ID to an event and stores it in the channel's history, returning the identified event.
+func (r *sseRepository) Add(channel, name, data string) eventsource.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	event := sseEvent{id: strconv.FormatUint(r.seq, 10), event: name, data: data}
+	history := append(r.history[channel], storedSSEEvent{seq: r.seq, event: event, added: time.Now()})
+	if len(history) > r.maxEvents {
+		history = history[len(history)-r.maxEvents:]
+	}
+	r.history[channel] = history
+	return event
+}
+
+// Replay implements eventsource.Repository. IDs that weren't assigned by this repository replay the whole retained
+// history, since there's no telling what the client has already seen.
+func (r *sseRepository) Replay(channel, id string) chan eventsource.Event {
+	lastSeq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		lastSeq = 0
+	}
+	cutoff := time.Now().Add(-r.maxAge)
+
+	r.mu.RLock()
+	var events []eventsource.Event
+	for _, stored := range r.history[channel] {
+		if stored.seq > lastSeq && stored.added.After(cutoff) {
+			events = append(events, stored.event)
+		}
+	}
+	r.mu.RUnlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+	out := make(chan eventsource.Event, len(events))
+	for _, event := range events {
+		out <- event
+	}
+	close(out)
+	return out
+}
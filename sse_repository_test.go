@@ -0,0 +1,54 @@
+package sdk_proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/eventsource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replayed(ch chan eventsource.Event) []string {
+	var data []string
+	if ch == nil {
+		return data
+	}
+	for event := range ch {
+		data = append(data, event.Data())
+	}
+	return data
+}
+
+func TestSSERepositoryReplay(t *testing.T) {
+	repo := newSSERepository(3, time.Minute)
+	first := repo.Add("key", "", "1")
+	repo.Add("key", "", "2")
+	repo.Add("other", "", "other")
+	third := repo.Add("key", "", "3")
+
+	assert.Equal(t, []string{"2", "3"}, replayed(repo.Replay("key", first.Id())))
+	assert.Empty(t, replayed(repo.Replay("key", third.Id())))
+	// IDs we didn't hand out get everything we still have
+	assert.Equal(t, []string{"1", "2", "3"}, replayed(repo.Replay("key", "not-an-id")))
+}
+
+func TestSSERepositoryBounds(t *testing.T) {
+	repo := newSSERepository(2, time.Minute)
+	first := repo.Add("key", "", "1")
+	repo.Add("key", "", "2")
+	repo.Add("key", "", "3")
+	assert.Equal(t, []string{"2", "3"}, replayed(repo.Replay("key", first.Id())))
+
+	expiring := newSSERepository(10, time.Millisecond)
+	event := expiring.Add("key", "", "1")
+	expiring.Add("key", "", "2")
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, expiring.Replay("key", event.Id()))
+}
+
+func TestSSERepositoryIDsIncrease(t *testing.T) {
+	first := newSSERepository(1, time.Minute).Add("key", "", "1")
+	second := newSSERepository(1, time.Minute).Add("key", "", "1")
+	require.Less(t, first.Id(), second.Id())
+}
@@ -0,0 +1,123 @@
+package sdk_proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/launchdarkly/eventsource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefetchConfigEvent(t *testing.T) {
+	data, err := refetchConfigEvent(watchedConfig{ETag: `"abc"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data":"{\"etag\":\"\\\"abc\\\"\",\"lastModified\":1445412480000,\"type\":\"refetchConfig\"}"}`, data)
+	assert.Equal(t, `"abc"`, refetchConfigETag(data))
+	assert.Empty(t, refetchConfigETag(`{"data":"{\"type\":\"other\",\"etag\":\"x\"}"}`))
+}
+
+func TestSSEAnnouncements(t *testing.T) {
+	var announcements sseAnnouncements
+	upstream, err := refetchConfigEvent(watchedConfig{ETag: `"1"`})
+	require.NoError(t, err)
+	announcements.recordUpstream(upstream)
+
+	assert.False(t, announcements.shouldAnnounce(`"1"`))
+	assert.True(t, announcements.shouldAnnounce(`"2"`))
+
+	// An upstream update we can't identify covers the next change
+	announcements.recordUpstream(`{"data":"{\"type\":\"refetchConfig\"}"}`)
+	assert.False(t, announcements.shouldAnnounce(`"3"`))
+	assert.True(t, announcements.shouldAnnounce(`"4"`))
+}
+
+func newSSETestServer(t *testing.T, instance *ProxyInstance) string {
+	gin.SetMode(gin.TestMode)
+	instance.SDKKey = "dvc_server_sse"
+	instance.sseServer = eventsource.NewServer()
+	instance.sseServer.ReplayAll = false
+	instance.sseServer.MaxConnTime = time.Duration(instance.SSEMaxConnectionMS) * time.Millisecond
+	server := httptest.NewServer(newRouter(nil, instance))
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+		instance.sseServer.Close()
+	})
+	return server.URL + "/event-stream?sdkKey=dvc_server_sse"
+}
+
+func openSSEStream(t *testing.T, ctx context.Context, url string) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestSSERetryAndKeepalive(t *testing.T) {
+	instance := &ProxyInstance{SSERetryMS: 1500}
+	url := newSSETestServer(t, instance)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go instance.sseHeartbeat(ctx, 20*time.Millisecond)
+
+	resp := openSSEStream(t, ctx, url)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "retry: 1500\n", line, "the retry field comes first")
+	for {
+		line, err = reader.ReadString('\n')
+		require.NoError(t, err)
+		if strings.TrimSpace(line) != "" {
+			break
+		}
+	}
+	assert.Equal(t, ":keepalive\n", line)
+}
+
+func TestSSEMaxConnectionTime(t *testing.T) {
+	instance := &ProxyInstance{SSEMaxConnectionMS: 100}
+	url := newSSETestServer(t, instance)
+
+	started := time.Now()
+	resp := openSSEStream(t, context.Background(), url)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	// The server ends the stream, so reading it to the end returns
+	_, err := io.Copy(io.Discard, resp.Body)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestSSEMaxSubscribers(t *testing.T) {
+	instance := &ProxyInstance{SSEMaxSubscribers: 1}
+	url := newSSETestServer(t, instance)
+	subscribers := func() int32 { return atomic.LoadInt32(&instance.sseSubscribers) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := openSSEStream(t, ctx, url)
+	require.Equal(t, http.StatusOK, first.StatusCode)
+	assert.Eventually(t, func() bool { return subscribers() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	second := openSSEStream(t, context.Background(), url)
+	assert.Equal(t, http.StatusServiceUnavailable, second.StatusCode)
+	assert.Equal(t, int32(1), subscribers(), "a rejected connection isn't counted")
+
+	// Disconnecting frees up the slot
+	cancel()
+	assert.Eventually(t, func() bool { return subscribers() == 0 }, 5*time.Second, 10*time.Millisecond)
+	third := openSSEStream(t, context.Background(), url)
+	assert.Equal(t, http.StatusOK, third.StatusCode)
+}
@@ -0,0 +1,134 @@
+package sdk_proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+)
+
+// staticConfigServer is a loopback config CDN serving a config supplied to the proxy, rather than one from DevCycle.
+// It also accepts events, handing them to onEvents if set and otherwise discarding them, so that clients pointed at it
+// never reach the network.
+type staticConfigServer struct {
+	listener net.Listener
+	server   *http.Server
+
+	mu           sync.RWMutex
+	config       []byte
+	etag         string
+	lastModified string
+}
+
+func newStaticConfigServer(config []byte, onEvents func(payload []byte)) (*staticConfigServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &staticConfigServer{listener: listener}
+	s.SetConfig(config)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config/", s.handleConfig)
+	mux.HandleFunc("/v1/events/batch", func(w http.ResponseWriter, req *http.Request) {
+		if onEvents != nil {
+			if payload, err := io.ReadAll(req.Body); err == nil {
+				onEvents(payload)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error running static config server: %s", err)
+		}
+	}()
+	return s, nil
+}
+
+func (s *staticConfigServer) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+// SetConfig replaces the config served, which clients pick up the next time they poll.
+func (s *staticConfigServer) SetConfig(config []byte) {
+	hash := sha256.Sum256(config)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.etag = `"` + hex.EncodeToString(hash[:16]) + `"`
+	s.lastModified = time.Now().UTC().Format(http.TimeFormat)
+}
+
+func (s *staticConfigServer) handleConfig(w http.ResponseWriter, req *http.Request) {
+	s.mu.RLock()
+	config, etag, lastModified := s.config, s.etag, s.lastModified
+	s.mu.RUnlock()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(config)
+}
+
+func (s *staticConfigServer) Close() error {
+	return s.server.Close()
+}
+
+// staticConfigClient is a DevCycle client that evaluates against a supplied config, using the same local bucketing as
+// the instance's own client, without sending events anywhere.
+type staticConfigClient struct {
+	*devcycle.Client
+	server *staticConfigServer
+}
+
+// newStaticConfigClient creates a client for a supplied config. pollingInterval is how often the client checks for a
+// config replaced with SetConfig.
+func newStaticConfigClient(sdkKey string, config []byte, platformData devcycle.PlatformData, pollingInterval time.Duration) (*staticConfigClient, error) {
+	server, err := newStaticConfigServer(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	platformData.SdkType = "server"
+	options := devcycle.Options{
+		EnableEdgeDB:                 false,
+		EnableCloudBucketing:         false,
+		ConfigPollingIntervalMS:      pollingInterval,
+		RequestTimeout:               5 * time.Second,
+		DisableAutomaticEventLogging: true,
+		DisableCustomEventLogging:    true,
+		DisableRealtimeUpdates:       true,
+		ConfigCDNURI:                 server.URL(),
+		EventsAPIURI:                 server.URL(),
+		AdvancedOptions: devcycle.AdvancedOptions{
+			OverridePlatformData: &platformData,
+		},
+	}
+	options.CheckDefaults()
+	client, err := devcycle.NewClient(sdkKey, &options)
+	if err != nil {
+		_ = server.Close()
+		return nil, fmt.Errorf("error creating DevCycle client for config: %v", err)
+	}
+	return &staticConfigClient{Client: client, server: server}, nil
+}
+
+func (c *staticConfigClient) SetConfig(config []byte) {
+	c.server.SetConfig(config)
+}
+
+func (c *staticConfigClient) Close() error {
+	err := c.Client.Close()
+	_ = c.server.Close()
+	return err
+}
@@ -0,0 +1,32 @@
+package sdk_proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticConfigServer(t *testing.T) {
+	server, err := newStaticConfigServer([]byte(`{"version":1}`), nil)
+	require.NoError(t, err)
+	defer server.Close()
+
+	status, etag, body := fetchConfig(t, server.URL(), "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"version":1}`, body)
+	status, _, _ = fetchConfig(t, server.URL(), etag)
+	assert.Equal(t, http.StatusNotModified, status)
+
+	server.SetConfig([]byte(`{"version":2}`))
+	status, newETag, body := fetchConfig(t, server.URL(), etag)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotEqual(t, etag, newETag)
+	assert.Equal(t, `{"version":2}`, body)
+
+	resp, err := http.Post(server.URL()+"/v1/events/batch", "application/json", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
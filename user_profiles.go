@@ -0,0 +1,333 @@
+package sdk_proxy
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+)
+
+type UserProfilesConfig struct {
+	Enabled            bool   `json:"enabled,omitempty" desc:"Whether to keep a store of user profiles, used to fill in the attributes of users sent with only a user ID. Defaults to false."`
+	SnapshotPath       string `json:"snapshotPath,omitempty" split_words:"true" desc:"The NDJSON file the store is loaded from on startup and periodically snapshotted to. Profiles are only kept in memory if unset."`
+	SnapshotIntervalMS int64  `json:"snapshotIntervalMS,omitempty" split_words:"true" desc:"The interval at which changes to the store are snapshotted in milliseconds. Defaults to 60000."`
+	TTLMS              int64  `json:"ttlMS,omitempty" envconfig:"TTL_MS" desc:"How long a profile is kept after it was last written in milliseconds. Profiles don't expire if unset."`
+	MaxProfiles        int    `json:"maxProfiles,omitempty" split_words:"true" desc:"The maximum number of profiles kept, beyond which the least recently used are evicted. Defaults to 100000."`
+	MaxProfileBytes    int    `json:"maxProfileBytes,omitempty" split_words:"true" desc:"The maximum size of a single profile as JSON, beyond which it is rejected. Defaults to 16384."`
+}
+
+func (c *UserProfilesConfig) Default() {
+	if !c.Enabled {
+		return
+	}
+	if c.SnapshotIntervalMS == 0 {
+		c.SnapshotIntervalMS = 60000
+	}
+	if c.MaxProfiles == 0 {
+		c.MaxProfiles = 100000
+	}
+	if c.MaxProfileBytes == 0 {
+		c.MaxProfileBytes = 16 * 1024
+	}
+}
+
+// UserProfile is the stored attributes of a user, filled in to requests that only carry the user's ID.
+type UserProfile struct {
+	UserId            string                 `json:"user_id"`
+	Email             string                 `json:"email,omitempty"`
+	Country           string                 `json:"country,omitempty"`
+	CustomData        map[string]interface{} `json:"customData,omitempty"`
+	PrivateCustomData map[string]interface{} `json:"privateCustomData,omitempty"`
+	UpdatedAt         time.Time              `json:"updatedAt"`
+}
+
+func userProfileFromUser(user devcycle.User) UserProfile {
+	return UserProfile{
+		UserId:            user.UserId,
+		Email:             user.Email,
+		Country:           user.Country,
+		CustomData:        user.CustomData,
+		PrivateCustomData: user.PrivateCustomData,
+	}
+}
+
+// Whether a user carries nothing but its ID, so its other attributes should come from its profile.
+func userOnlyHasID(user *devcycle.User) bool {
+	return user.UserId != "" && user.Email == "" && user.Name == "" && user.Language == "" && user.Country == "" &&
+		user.AppVersion == "" && user.AppBuild == "" && user.DeviceModel == "" &&
+		len(user.CustomData) == 0 && len(user.PrivateCustomData) == 0
+}
+
+// Copies the custom data, so changes to the user by hooks or redaction don't reach the stored profile.
+func (p UserProfile) applyTo(user *devcycle.User) {
+	user.Email = p.Email
+	user.Country = p.Country
+	user.CustomData = maps.Clone(p.CustomData)
+	user.PrivateCustomData = maps.Clone(p.PrivateCustomData)
+}
+
+// userProfileStore is an in-memory store of user profiles, evicting the least recently used beyond its size limit
+// and dropping profiles once they outlive their TTL. Changes are periodically written to a snapshot file, which the
+// store is loaded from on startup.
+type userProfileStore struct {
+	config UserProfilesConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	profiles map[string]*list.Element
+	// Most recently used first
+	lru   *list.List
+	dirty bool
+
+	snapshotMu sync.Mutex
+	done       chan struct{}
+}
+
+func newUserProfileStore(ctx context.Context, config UserProfilesConfig) (*userProfileStore, error) {
+	config.Default()
+	s := &userProfileStore{
+		config:   config,
+		now:      time.Now,
+		profiles: map[string]*list.Element{},
+		lru:      list.New(),
+		done:     make(chan struct{}),
+	}
+	if config.SnapshotPath == "" {
+		close(s.done)
+		return s, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	go s.run(ctx, time.Duration(config.SnapshotIntervalMS)*time.Millisecond)
+	return s, nil
+}
+
+// Load the profiles from the snapshot file left behind by a previous run, if there is one.
+func (s *userProfileStore) load() error {
+	file, err := os.Open(s.config.SnapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading user profile snapshot: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	loaded := 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var profile UserProfile
+		if err = json.Unmarshal([]byte(text), &profile); err != nil {
+			return fmt.Errorf("invalid user profile on line %d of %s: %v", line, s.config.SnapshotPath, err)
+		}
+		if profile.UpdatedAt.IsZero() {
+			profile.UpdatedAt = s.now()
+		}
+		if s.expired(profile) || profile.UserId == "" {
+			continue
+		}
+		s.insert(profile)
+		loaded++
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("error reading user profile snapshot: %w", err)
+	}
+	if loaded > 0 {
+		log.Printf("Loaded %d user profiles from %s", loaded, s.config.SnapshotPath)
+	}
+	return nil
+}
+
+// Snapshot the store whenever it has changed, until ctx is cancelled, then take a final snapshot.
+func (s *userProfileStore) run(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Snapshot(); err != nil {
+				log.Printf("Error snapshotting user profiles: %s", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Printf("Error snapshotting user profiles: %s", err)
+			}
+		}
+	}
+}
+
+// Close waits for the final snapshot, once the context the store was created with is cancelled.
+func (s *userProfileStore) Close() {
+	<-s.done
+}
+
+func (s *userProfileStore) expired(profile UserProfile) bool {
+	return s.config.TTLMS > 0 && s.now().Sub(profile.UpdatedAt) > time.Duration(s.config.TTLMS)*time.Millisecond
+}
+
+// Add or replace a profile, evicting the least recently used beyond the size limit. Must be called with the lock held.
+func (s *userProfileStore) insert(profile UserProfile) {
+	if element, ok := s.profiles[profile.UserId]; ok {
+		element.Value = profile
+		s.lru.MoveToFront(element)
+	} else {
+		s.profiles[profile.UserId] = s.lru.PushFront(profile)
+	}
+	for s.config.MaxProfiles > 0 && s.lru.Len() > s.config.MaxProfiles {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.profiles, oldest.Value.(UserProfile).UserId)
+	}
+}
+
+// Put stores a profile, replacing any stored for the same user.
+func (s *userProfileStore) Put(profile UserProfile) error {
+	if profile.UserId == "" {
+		return fmt.Errorf("a user_id is required")
+	}
+	profile.UpdatedAt = s.now().UTC()
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	if s.config.MaxProfileBytes > 0 && len(data) > s.config.MaxProfileBytes {
+		return fmt.Errorf("profile of %d bytes exceeds the limit of %d", len(data), s.config.MaxProfileBytes)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(profile)
+	s.dirty = true
+	return nil
+}
+
+// Get returns the profile stored for a user, if there is one that hasn't expired.
+func (s *userProfileStore) Get(userID string) (UserProfile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.profiles[userID]
+	if !ok {
+		return UserProfile{}, false
+	}
+	profile := element.Value.(UserProfile)
+	if s.expired(profile) {
+		s.lru.Remove(element)
+		delete(s.profiles, userID)
+		s.dirty = true
+		return UserProfile{}, false
+	}
+	s.lru.MoveToFront(element)
+	return profile, true
+}
+
+// Delete removes the profile stored for a user, returning whether there was one.
+func (s *userProfileStore) Delete(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.profiles[userID]
+	if !ok {
+		return false
+	}
+	s.lru.Remove(element)
+	delete(s.profiles, userID)
+	s.dirty = true
+	return true
+}
+
+func (s *userProfileStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// Snapshot writes the unexpired profiles to the snapshot file if they've changed since the last snapshot, replacing
+// the file atomically so a crash never leaves it half written.
+func (s *userProfileStore) Snapshot() error {
+	if s.config.SnapshotPath == "" {
+		return nil
+	}
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	profiles := make([]UserProfile, 0, s.lru.Len())
+	// Oldest first, so loading the snapshot restores the same order
+	for element := s.lru.Back(); element != nil; element = element.Prev() {
+		if profile := element.Value.(UserProfile); !s.expired(profile) {
+			profiles = append(profiles, profile)
+		}
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	err := s.writeSnapshot(profiles)
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *userProfileStore) writeSnapshot(profiles []UserProfile) error {
+	tmp := s.config.SnapshotPath + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, profile := range profiles {
+		if err = encoder.Encode(profile); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	// The snapshot replaces the previous one, so it has to be on disk before the rename
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.config.SnapshotPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(s.config.SnapshotPath))
+}
+
+// Fill in the attributes of a user that only carries its ID from its stored profile, if the instance has one.
+func (i *ProxyInstance) applyUserProfile(user *devcycle.User) {
+	if i.userProfiles == nil || !userOnlyHasID(user) {
+		return
+	}
+	if profile, ok := i.userProfiles.Get(user.UserId); ok {
+		profile.applyTo(user)
+	}
+}
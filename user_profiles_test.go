@@ -0,0 +1,147 @@
+package sdk_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserProfileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.ndjson")
+	config := UserProfilesConfig{Enabled: true, SnapshotPath: path, TTLMS: 60000, MaxProfiles: 2, MaxProfileBytes: 200}
+	ctx, cancel := context.WithCancel(context.Background())
+	store, err := newUserProfileStore(ctx, config)
+	require.NoError(t, err)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Put(UserProfile{UserId: "a", Email: "a@example.com"}))
+	require.NoError(t, store.Put(UserProfile{UserId: "b", Country: "CA"}))
+	assert.Error(t, store.Put(UserProfile{Email: "no-id@example.com"}))
+	assert.Error(t, store.Put(UserProfile{UserId: "big", CustomData: map[string]interface{}{"bio": strings.Repeat("x", 200)}}))
+
+	// Reading a makes b the least recently used, so it's evicted for c
+	_, ok := store.Get("a")
+	assert.True(t, ok)
+	require.NoError(t, store.Put(UserProfile{UserId: "c", CustomData: map[string]interface{}{"plan": "enterprise"}}))
+	_, ok = store.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, store.Len())
+
+	// a expires a minute after it was written, and c is rewritten so it doesn't
+	now = now.Add(45 * time.Second)
+	require.NoError(t, store.Put(UserProfile{UserId: "c", CustomData: map[string]interface{}{"plan": "team"}}))
+	now = now.Add(30 * time.Second)
+	_, ok = store.Get("a")
+	assert.False(t, ok)
+
+	// The final snapshot is written on shutdown, and loaded by the next store
+	cancel()
+	store.Close()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	reloaded, err := newUserProfileStore(context.Background(), config)
+	require.NoError(t, err)
+	profile, ok := reloaded.Get("c")
+	require.True(t, ok)
+	assert.Equal(t, "team", profile.CustomData["plan"])
+
+	require.NoError(t, os.WriteFile(path, []byte("{\"user_id\":\"d\"}\nnot json\n"), 0600))
+	_, err = newUserProfileStore(context.Background(), config)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestUserProfileAdminAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := newUserProfileStore(context.Background(), UserProfilesConfig{Enabled: true})
+	require.NoError(t, err)
+	instance := &ProxyInstance{AdminToken: "admin-token", userProfiles: store}
+	r := newRouter(nil, instance)
+
+	request := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", "/admin/users", "application/x-ndjson",
+		"{\"user_id\":\"a\",\"email\":\"a@example.com\",\"country\":\"CA\"}\n\n{\"email\":\"no-id@example.com\"}\n{\"user_id\":\"b\",\"privateCustomData\":{\"tier\":3}}\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"imported":2,"rejected":1,"profiles":2}`, w.Body.String())
+
+	w = request("POST", "/admin/users", "application/json", `[{"user_id":"c"}]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/admin/users", "application/x-ndjson", "{").Code)
+
+	w = request("PUT", "/admin/users/d", "application/json", `{"email":"d@example.com","customData":{"plan":"team"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"user_id":"d"`)
+
+	w = request("GET", "/admin/users/a", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"country":"CA"`)
+
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/admin/users/a", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, request("GET", "/admin/users/a", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/admin/users/a", "", "").Code)
+
+	instance.userProfiles = nil
+	assert.Equal(t, http.StatusNotFound, request("GET", "/admin/users/d", "", "").Code)
+}
+
+func TestGetUserFromBodyWithProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := newUserProfileStore(context.Background(), UserProfilesConfig{Enabled: true})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(UserProfile{
+		UserId:            "known",
+		Email:             "known@example.com",
+		Country:           "CA",
+		CustomData:        map[string]interface{}{"plan": "enterprise"},
+		PrivateCustomData: map[string]interface{}{"tier": 3},
+	}))
+	instance := &ProxyInstance{userProfiles: store}
+
+	getUser := func(body string) *devcycle.User {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/variables", strings.NewReader(body))
+		c.Set("instance", instance)
+		return getUserFromBody(c)
+	}
+
+	user := getUser(`{"user_id":"known"}`)
+	require.NotNil(t, user)
+	assert.Equal(t, "known@example.com", user.Email)
+	assert.Equal(t, "CA", user.Country)
+	assert.Equal(t, "enterprise", user.CustomData["plan"])
+	assert.Equal(t, 3, user.PrivateCustomData["tier"])
+
+	// Changing the user doesn't change the stored profile
+	user.CustomData["plan"] = "changed"
+	profile, _ := store.Get("known")
+	assert.Equal(t, "enterprise", profile.CustomData["plan"])
+
+	// Users carrying their own attributes, or without a profile, are left as sent
+	user = getUser(`{"user_id":"known","email":"other@example.com"}`)
+	assert.Equal(t, "other@example.com", user.Email)
+	assert.Empty(t, user.Country)
+	user = getUser(`{"user_id":"unknown"}`)
+	assert.Empty(t, user.Email)
+}
@@ -0,0 +1,122 @@
+package sdk_proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// UserRedactionConfig controls how users are scrubbed from events before they leave the proxy. Fields are either top
+// level user properties using their JSON names (email, name, country, ...), or keys inside customData and
+// privateCustomData such as customData.plan, where customData.* matches every key. Evaluation always uses the full user.
+type UserRedactionConfig struct {
+	Drop       []string       `json:"drop,omitempty" desc:"User fields to remove from events before they are sent upstream, e.g. email,name,customData.plan."`
+	Hash       []string       `json:"hash,omitempty" desc:"User fields to replace with an HMAC-SHA256 of their value before events are sent upstream."`
+	Truncate   map[string]int `json:"truncate,omitempty" desc:"User fields to truncate before events are sent upstream, as field:length pairs e.g. name:1."`
+	HashSecret string         `json:"hashSecret,omitempty" split_words:"true" desc:"The secret key used to hash user fields. Required when hash is set."`
+}
+
+func (c *UserRedactionConfig) Enabled() bool {
+	return len(c.Drop) > 0 || len(c.Hash) > 0 || len(c.Truncate) > 0
+}
+
+func (c *UserRedactionConfig) Validate() error {
+	if len(c.Hash) > 0 && c.HashSecret == "" {
+		return fmt.Errorf("a hash secret must be set to hash user fields")
+	}
+	for field, length := range c.Truncate {
+		if length < 0 {
+			return fmt.Errorf("invalid truncate length %d for user field %s", length, field)
+		}
+	}
+	return nil
+}
+
+// RedactUser applies the configured rules to a user in its JSON form, in place.
+func (c *UserRedactionConfig) RedactUser(user map[string]interface{}) {
+	if user == nil {
+		return
+	}
+	for _, field := range c.Drop {
+		applyToUserField(user, field, func(interface{}) (interface{}, bool) {
+			return nil, false
+		})
+	}
+	for _, field := range c.Hash {
+		applyToUserField(user, field, func(value interface{}) (interface{}, bool) {
+			return hashUserValue(c.HashSecret, value), true
+		})
+	}
+	for field, length := range c.Truncate {
+		applyToUserField(user, field, func(value interface{}) (interface{}, bool) {
+			s, ok := value.(string)
+			if !ok {
+				return value, true
+			}
+			if runes := []rune(s); len(runes) > length {
+				return string(runes[:length]), true
+			}
+			return s, true
+		})
+	}
+}
+
+// Redact the user of every item in an events API batch, in place.
+func (c *UserRedactionConfig) RedactBatch(batch []interface{}) {
+	for _, batchItem := range batch {
+		batchMap, ok := batchItem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if user, ok := batchMap["user"].(map[string]interface{}); ok {
+			c.RedactUser(user)
+		}
+	}
+}
+
+// Call fn with the current value of a user field, replacing it with the returned value or deleting it if fn
+// returns false. Fields that aren't set are left alone.
+func applyToUserField(user map[string]interface{}, field string, fn func(interface{}) (interface{}, bool)) {
+	apply := func(m map[string]interface{}, key string) {
+		value, ok := m[key]
+		if !ok {
+			return
+		}
+		if newValue, keep := fn(value); keep {
+			m[key] = newValue
+		} else {
+			delete(m, key)
+		}
+	}
+
+	parent, key, nested := strings.Cut(field, ".")
+	if !nested {
+		apply(user, field)
+		return
+	}
+	data, ok := user[parent].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if key == "*" {
+		for k := range data {
+			apply(data, k)
+		}
+		return
+	}
+	apply(data, key)
+}
+
+func hashUserValue(secret string, value interface{}) string {
+	s, ok := value.(string)
+	if !ok {
+		encoded, _ := json.Marshal(value)
+		s = string(encoded)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
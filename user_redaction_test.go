@@ -0,0 +1,66 @@
+package sdk_proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactUser(t *testing.T) {
+	config := UserRedactionConfig{
+		Drop:       []string{"email", "privateCustomData.*"},
+		Hash:       []string{"customData.accountId"},
+		Truncate:   map[string]int{"name": 1, "country": 5},
+		HashSecret: "secret",
+	}
+	require.NoError(t, config.Validate())
+
+	user := map[string]interface{}{
+		"user_id": "user-1",
+		"email":   "jane@example.com",
+		"name":    "Jane",
+		"country": "CA",
+		"customData": map[string]interface{}{
+			"accountId": "acct-123",
+			"plan":      "enterprise",
+		},
+		"privateCustomData": map[string]interface{}{
+			"ssn": "123-45-6789",
+		},
+	}
+	config.RedactUser(user)
+
+	assert.Equal(t, map[string]interface{}{
+		"user_id": "user-1",
+		"name":    "J",
+		"country": "CA",
+		"customData": map[string]interface{}{
+			// HMAC-SHA256 of "acct-123" keyed by "secret"
+			"accountId": "7a3b8a0cd270ae44608dbcd3223c24d4bf5c601525bf4435d220a0d57aa17cd2",
+			"plan":      "enterprise",
+		},
+		"privateCustomData": map[string]interface{}{},
+	}, user)
+	assert.NotEqual(t, hashUserValue("other", "acct-123"), hashUserValue("secret", "acct-123"))
+}
+
+func TestRedactBatch(t *testing.T) {
+	config := UserRedactionConfig{Drop: []string{"email"}}
+	batch := []interface{}{
+		map[string]interface{}{
+			"user":   map[string]interface{}{"user_id": "a", "email": "a@example.com"},
+			"events": []interface{}{},
+		},
+		"not an item",
+	}
+	config.RedactBatch(batch)
+	assert.Equal(t, map[string]interface{}{"user_id": "a"}, batch[0].(map[string]interface{})["user"])
+}
+
+func TestUserRedactionValidate(t *testing.T) {
+	config := UserRedactionConfig{Hash: []string{"email"}}
+	assert.True(t, config.Enabled())
+	assert.EqualError(t, config.Validate(), "a hash secret must be set to hash user fields")
+	assert.False(t, (&UserRedactionConfig{}).Enabled())
+}
@@ -0,0 +1,124 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+)
+
+// Variable types, as named in the DevCycle config.
+const (
+	VariableTypeBoolean = "Boolean"
+	VariableTypeNumber  = "Number"
+	VariableTypeString  = "String"
+	VariableTypeJSON    = "JSON"
+)
+
+// Details of why a variable was defaulted, given in its evaluation reason.
+const (
+	DefaultDetailsMissingVariable = "Missing Variable"
+	DefaultDetailsUserNotTargeted = "User Not Targeted"
+	DefaultDetailsTypeMismatch    = "Variable Type Mismatch"
+	DefaultDetailsKillSwitch      = "Kill Switch"
+)
+
+// EvalReason explains a variable's value.
+type EvalReason struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+// VariableResponse is returned by /v1/variables/:key when the caller supplies a default value.
+type VariableResponse struct {
+	devcycle.BaseVariable
+	DefaultValue interface{} `json:"defaultValue"`
+	IsDefaulted  bool        `json:"isDefaulted"`
+	Eval         *EvalReason `json:"eval,omitempty"`
+}
+
+// variableRequest holds the optional defaultValue and type fields sent alongside the user to /v1/variables/:key.
+type variableRequest struct {
+	hasDefault   bool
+	defaultValue interface{}
+	variableType string
+}
+
+func parseVariableRequest(body []byte) (variableRequest, error) {
+	var fields struct {
+		DefaultValue *json.RawMessage `json:"defaultValue"`
+		Type         string           `json:"type"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return variableRequest{}, err
+	}
+	request := variableRequest{variableType: fields.Type}
+	switch fields.Type {
+	case "", VariableTypeBoolean, VariableTypeNumber, VariableTypeString, VariableTypeJSON:
+	default:
+		return request, fmt.Errorf("invalid variable type %q, expected one of Boolean, Number, String or JSON", fields.Type)
+	}
+	if fields.DefaultValue == nil || string(*fields.DefaultValue) == "null" {
+		if fields.Type != "" {
+			return request, fmt.Errorf("a defaultValue is required when a type is given")
+		}
+		return request, nil
+	}
+	request.hasDefault = true
+	if err := json.Unmarshal(*fields.DefaultValue, &request.defaultValue); err != nil {
+		return request, err
+	}
+	defaultType := variableType(request.defaultValue)
+	if request.variableType == "" {
+		request.variableType = defaultType
+	} else if request.variableType != defaultType {
+		return request, fmt.Errorf("defaultValue is a %s, but the variable type is %s", defaultType, request.variableType)
+	}
+	return request, nil
+}
+
+// Resolve an evaluated variable against the caller's default value, defaulting it when the config's type doesn't
+// match the type the caller expects. configTypes maps variable keys to their types in the config, and is used to
+// explain why a variable was defaulted.
+func (r variableRequest) resolve(variable devcycle.Variable, killed bool, configTypes map[string]string) VariableResponse {
+	response := VariableResponse{BaseVariable: variable.BaseVariable, DefaultValue: r.defaultValue}
+	details := ""
+	configType, inConfig := configTypes[variable.Key]
+	switch {
+	case killed:
+		details = DefaultDetailsKillSwitch
+	case !variable.IsDefaulted && variable.Type_ != r.variableType:
+		details = DefaultDetailsTypeMismatch
+	case variable.IsDefaulted && inConfig && configType != r.variableType:
+		details = DefaultDetailsTypeMismatch
+	case variable.IsDefaulted && !inConfig:
+		details = DefaultDetailsMissingVariable
+	case variable.IsDefaulted:
+		details = DefaultDetailsUserNotTargeted
+	default:
+		return response
+	}
+	response.Type_ = r.variableType
+	response.Value = r.defaultValue
+	response.IsDefaulted = true
+	response.Eval = &EvalReason{Reason: "DEFAULT", Details: details}
+	return response
+}
+
+// The types of the variables in a config, by key.
+func configVariableTypes(rawConfig []byte) (map[string]string, error) {
+	var config struct {
+		Variables []struct {
+			Key  string `json:"key"`
+			Type string `json:"type"`
+		} `json:"variables"`
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(config.Variables))
+	for _, variable := range config.Variables {
+		types[variable.Key] = variable.Type
+	}
+	return types, nil
+}
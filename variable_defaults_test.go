@@ -0,0 +1,54 @@
+package sdk_proxy
+
+import (
+	"testing"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVariableRequest(t *testing.T) {
+	request, err := parseVariableRequest([]byte(`{"user_id": "u1"}`))
+	require.NoError(t, err)
+	assert.False(t, request.hasDefault)
+
+	request, err = parseVariableRequest([]byte(`{"user_id": "u1", "defaultValue": 3}`))
+	require.NoError(t, err)
+	assert.True(t, request.hasDefault)
+	assert.Equal(t, VariableTypeNumber, request.variableType)
+
+	request, err = parseVariableRequest([]byte(`{"user_id": "u1", "defaultValue": {"a": 1}, "type": "JSON"}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1.0}, request.defaultValue)
+
+	_, err = parseVariableRequest([]byte(`{"defaultValue": "on", "type": "Boolean"}`))
+	assert.EqualError(t, err, "defaultValue is a String, but the variable type is Boolean")
+	_, err = parseVariableRequest([]byte(`{"defaultValue": "on", "type": "Text"}`))
+	assert.Error(t, err)
+	_, err = parseVariableRequest([]byte(`{"type": "String"}`))
+	assert.Error(t, err)
+}
+
+func TestResolveVariableRequest(t *testing.T) {
+	request := variableRequest{hasDefault: true, defaultValue: "fallback", variableType: VariableTypeString}
+	configTypes := map[string]string{"served": "String", "untargeted": "String", "number": "Number"}
+
+	served := request.resolve(devcycle.Variable{BaseVariable: devcycle.BaseVariable{Key: "served", Type_: "String", Value: "on"}}, false, configTypes)
+	assert.Equal(t, "on", served.Value)
+	assert.False(t, served.IsDefaulted)
+	assert.Nil(t, served.Eval)
+	assert.Equal(t, "fallback", served.DefaultValue)
+
+	details := func(variable devcycle.Variable, killed bool) string {
+		response := request.resolve(variable, killed, configTypes)
+		assert.True(t, response.IsDefaulted)
+		assert.Equal(t, "fallback", response.Value)
+		return response.Eval.Details
+	}
+	assert.Equal(t, DefaultDetailsTypeMismatch, details(devcycle.Variable{BaseVariable: devcycle.BaseVariable{Key: "number", Type_: "Number", Value: 1.0}}, false))
+	assert.Equal(t, DefaultDetailsTypeMismatch, details(devcycle.Variable{BaseVariable: devcycle.BaseVariable{Key: "number"}, IsDefaulted: true}, false))
+	assert.Equal(t, DefaultDetailsUserNotTargeted, details(devcycle.Variable{BaseVariable: devcycle.BaseVariable{Key: "untargeted"}, IsDefaulted: true}, false))
+	assert.Equal(t, DefaultDetailsMissingVariable, details(devcycle.Variable{BaseVariable: devcycle.BaseVariable{Key: "missing"}, IsDefaulted: true}, false))
+	assert.Equal(t, DefaultDetailsKillSwitch, details(devcycle.Variable{BaseVariable: devcycle.BaseVariable{Key: "served"}, IsDefaulted: true}, true))
+}
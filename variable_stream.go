@@ -0,0 +1,160 @@
+package sdk_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// Events sent on a variable stream.
+const (
+	VariableStreamEventVariables = "variables"
+	VariableStreamEventDiff      = "variablesDiff"
+)
+
+// variablesDiff lists the variables whose evaluation changed for a user, and the keys of those no longer served.
+type variablesDiff struct {
+	Updated map[string]devcycle.ReadOnlyVariable `json:"updated"`
+	Removed []string                             `json:"removed"`
+}
+
+func (d variablesDiff) empty() bool {
+	return len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+func diffVariables(previous, current map[string]devcycle.ReadOnlyVariable) variablesDiff {
+	diff := variablesDiff{Updated: map[string]devcycle.ReadOnlyVariable{}, Removed: []string{}}
+	for key, variable := range current {
+		if old, ok := previous[key]; !ok || !reflect.DeepEqual(old, variable) {
+			diff.Updated[key] = variable
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	return diff
+}
+
+// VariableStream evaluates all variables for the user in the request body and streams them back over SSE, first in
+// full and then again each time a config change or the kill switch alters them. With ?diff=true, updates only contain
+// what changed.
+func VariableStream() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := c.Value("devcycle").(*devcycle.Client)
+		instance := c.Value("instance").(*ProxyInstance)
+		user := getUserFromBody(c)
+		if user == nil {
+			return
+		}
+		if instance.configWatcher == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message":    "Variable streams are not enabled for this instance",
+				"statusCode": http.StatusNotFound,
+			})
+			return
+		}
+		subscribers := atomic.AddInt32(&instance.sseSubscribers, 1)
+		defer atomic.AddInt32(&instance.sseSubscribers, -1)
+		if instance.SSEMaxSubscribers > 0 && subscribers > instance.SSEMaxSubscribers {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"message":    "Too many SSE connections",
+				"statusCode": http.StatusServiceUnavailable,
+			})
+			return
+		}
+		diff := c.Query("diff") == "true"
+
+		// Subscribe before the first evaluation, so a change in between isn't missed
+		changed := make(chan struct{}, 1)
+		notify := func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+		unsubscribeConfig := instance.configWatcher.Subscribe(func(_, _ watchedConfig) { notify() })
+		defer unsubscribeConfig()
+		unsubscribeKillSwitch := instance.killSwitch.Subscribe(notify)
+		defer unsubscribeKillSwitch()
+
+		variables, err := client.AllVariables(*user)
+		if err != nil {
+			log.Printf("Error evaluating variables for stream: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+		variables = instance.killSwitch.ApplyToVariables(variables)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+		if instance.SSERetryMS > 0 {
+			_, _ = fmt.Fprintf(c.Writer, "retry: %d\n\n", instance.SSERetryMS)
+		}
+		if err = writeSSEMessage(c.Writer, VariableStreamEventVariables, variables); err != nil {
+			return
+		}
+
+		var heartbeat <-chan time.Time
+		if instance.SSEHeartbeatMS > 0 {
+			ticker := time.NewTicker(time.Duration(instance.SSEHeartbeatMS) * time.Millisecond)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+		for {
+			select {
+			case <-changed:
+				// Assigned rather than declared, so that write errors below end the stream
+				var current map[string]devcycle.ReadOnlyVariable
+				current, err = client.AllVariables(*user)
+				if err != nil {
+					log.Printf("Error evaluating variables for stream: %s", err)
+					continue
+				}
+				current = instance.killSwitch.ApplyToVariables(current)
+				changes := diffVariables(variables, current)
+				variables = current
+				if changes.empty() {
+					continue
+				}
+				if diff {
+					err = writeSSEMessage(c.Writer, VariableStreamEventDiff, changes)
+				} else {
+					err = writeSSEMessage(c.Writer, VariableStreamEventVariables, current)
+				}
+			case <-heartbeat:
+				_, err = fmt.Fprint(c.Writer, ": keepalive\n\n")
+				c.Writer.Flush()
+			case <-c.Request.Context().Done():
+				return
+			case <-instance.ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeSSEMessage(w gin.ResponseWriter, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -0,0 +1,113 @@
+package sdk_proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	devcycle "github.com/devcyclehq/go-server-sdk/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffVariables(t *testing.T) {
+	variable := func(key string, value interface{}) devcycle.ReadOnlyVariable {
+		return devcycle.ReadOnlyVariable{BaseVariable: devcycle.BaseVariable{Key: key, Type_: "String", Value: value}}
+	}
+	previous := map[string]devcycle.ReadOnlyVariable{
+		"same":    variable("same", "a"),
+		"changed": variable("changed", "a"),
+		"removed": variable("removed", "a"),
+	}
+	current := map[string]devcycle.ReadOnlyVariable{
+		"same":    variable("same", "a"),
+		"changed": variable("changed", "b"),
+		"added":   variable("added", "a"),
+	}
+
+	diff := diffVariables(previous, current)
+	assert.Equal(t, map[string]devcycle.ReadOnlyVariable{
+		"changed": variable("changed", "b"),
+		"added":   variable("added", "a"),
+	}, diff.Updated)
+	assert.Equal(t, []string{"removed"}, diff.Removed)
+	assert.True(t, diffVariables(current, current).empty())
+}
+
+func TestVariableStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rawConfig := sdkTestConfig(t)
+	client, err := newStaticConfigClient("dvc_server_stream", rawConfig, devcycle.PlatformData{}, 50*time.Millisecond)
+	require.NoError(t, err)
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	instance := &ProxyInstance{
+		SDKKey:        "dvc_server_stream",
+		ctx:           ctx,
+		configWatcher: newConfigWatcher(client.GetRawConfig, 20*time.Millisecond),
+	}
+	go instance.configWatcher.run(ctx)
+	server := httptest.NewServer(newRouter(client.Client, instance))
+	defer server.Close()
+
+	streamCtx, disconnect := context.WithTimeout(context.Background(), 10*time.Second)
+	defer disconnect()
+	req, err := http.NewRequestWithContext(streamCtx, "POST", server.URL+"/v1/stream/variables?diff=true", strings.NewReader(`{"user_id": "u1", "email": "u1@example.com"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "dvc_server_stream")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reader := bufio.NewReader(resp.Body)
+	next := func() (string, string) {
+		var event string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = strings.TrimSpace(name)
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				return event, strings.TrimSpace(data)
+			}
+		}
+	}
+	event, data := next()
+	assert.Equal(t, VariableStreamEventVariables, event)
+	assert.Contains(t, data, `"new-checkout"`)
+
+	// Serving the control variation to internal users changes new-checkout, and only new-checkout, for u1
+	var config map[string]interface{}
+	require.NoError(t, json.Unmarshal(rawConfig, &config))
+	feature := config["features"].([]interface{})[0].(map[string]interface{})
+	target := feature["configuration"].(map[string]interface{})["targets"].([]interface{})[0].(map[string]interface{})
+	target["distribution"] = []map[string]interface{}{{"_variation": "var-a", "percentage": 1}}
+	changedConfig, err := json.Marshal(config)
+	require.NoError(t, err)
+	client.SetConfig(changedConfig)
+	event, data = next()
+	assert.Equal(t, VariableStreamEventDiff, event)
+	var diff variablesDiff
+	require.NoError(t, json.Unmarshal([]byte(data), &diff))
+	require.Contains(t, diff.Updated, "new-checkout")
+	assert.Equal(t, false, diff.Updated["new-checkout"].Value)
+	assert.Len(t, diff.Updated, 1)
+	assert.Empty(t, diff.Removed)
+
+	// Disconnecting ends the stream and drops its subscription
+	disconnect()
+	assert.Eventually(t, func() bool {
+		instance.configWatcher.mu.RLock()
+		defer instance.configWatcher.mu.RUnlock()
+		return atomic.LoadInt32(&instance.sseSubscribers) == 0 && len(instance.configWatcher.subscribers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}